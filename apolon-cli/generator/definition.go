@@ -2,18 +2,24 @@ package generator
 
 // FieldInfo represents metadata about a struct field
 type FieldInfo struct {
-	Name      string // Go field name
-	Column    string // Database column name
-	FieldType string // ORM field type (IntField, StringField, etc.)
-	GoType    string // Original Go type
-	IsPK      bool   // Is primary key
+	Name       string // Go field name
+	Column     string // Database column name
+	FieldType  string // ORM field type (IntField, StringField, etc.)
+	GoType     string // Original Go type
+	IsPK       bool   // Is primary key
+	Comparable bool   // Whether values can be compared with ==
+
+	// Nested holds the fields of an owned value type, e.g. Address.City;
+	// FieldType is empty for such groups
+	Nested []FieldInfo
 }
 
 // ModelInfo represents metadata about a model struct
 type ModelInfo struct {
 	Name          string      // Struct name
 	Table         string      // Table name
-	Fields        []FieldInfo // Field information
+	Fields        []FieldInfo // Fields with typed accessors
+	Properties    []FieldInfo // All mapped fields by dotted path, used for change detection
 	Package       string      // Package name
 	HasTimeImport bool        // Whether time.Time is used
 }
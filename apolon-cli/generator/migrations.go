@@ -0,0 +1,403 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/jkeresman01/apolon/apolon"
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// SnapshotFile is the name of the model snapshot kept next to the migrations
+const SnapshotFile = "apolon.snapshot.json"
+
+// Snapshot records the table definitions the latest migration brings the
+// database to. It is committed with the migrations and diffed against the
+// current models to produce the next migration. Seed rows are not
+// recorded, as HasData is configured in code the CLI does not run.
+type Snapshot struct {
+	Tables []*shared.SchemaInfo
+}
+
+// LoadSnapshot reads the model snapshot from a migrations directory. A
+// missing snapshot is empty, so the first migration creates every table.
+func LoadSnapshot(dir string) (*Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(dir, SnapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// SaveSnapshot writes the model snapshot to a migrations directory
+func SaveSnapshot(dir string, snapshot *Snapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, SnapshotFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadMigrations reads the migrations in a directory without compiling
+// it: SQL files are parsed directly and Go files are searched for
+// apolon.RegisterMigration calls with literal values
+func LoadMigrations(dir string) ([]*apolon.Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	var migrations []*apolon.Migration
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		switch {
+		case entry.IsDir():
+		case strings.HasSuffix(entry.Name(), ".sql"):
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read migrations: %w", err)
+			}
+			migration, err := apolon.ParseMigration(entry.Name(), string(content))
+			if err != nil {
+				return nil, err
+			}
+			migrations = append(migrations, migration)
+		case strings.HasSuffix(entry.Name(), ".go") && !strings.HasSuffix(entry.Name(), "_test.go"):
+			found, err := goMigrations(path)
+			if err != nil {
+				return nil, err
+			}
+			migrations = append(migrations, found...)
+		}
+	}
+	return migrations, nil
+}
+
+// goMigrations returns the migrations registered in a Go file
+func goMigrations(path string) ([]*apolon.Migration, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []*apolon.Migration
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) != 1 {
+			return err == nil
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		lit, isLit := call.Args[0].(*ast.CompositeLit)
+		if !ok || sel.Sel.Name != "RegisterMigration" || !isLit {
+			return true
+		}
+
+		var migration *apolon.Migration
+		migration, err = migrationLiteral(lit)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", path, err)
+			return false
+		}
+		migrations = append(migrations, migration)
+		return false
+	})
+	return migrations, err
+}
+
+// migrationLiteral reads an apolon.Migration composite literal with string
+// literal fields and an optional NoTransaction: true
+func migrationLiteral(lit *ast.CompositeLit) (*apolon.Migration, error) {
+	migration := &apolon.Migration{}
+	fields := map[string]*string{
+		"Version": &migration.Version,
+		"Name":    &migration.Name,
+		"Up":      &migration.Up,
+		"Down":    &migration.Down,
+	}
+
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, fmt.Errorf("migration fields must be keyed")
+		}
+		key, _ := kv.Key.(*ast.Ident)
+		if key != nil && key.Name == "NoTransaction" {
+			value, _ := kv.Value.(*ast.Ident)
+			if value == nil || (value.Name != "true" && value.Name != "false") {
+				return nil, fmt.Errorf("migration field NoTransaction must be true or false")
+			}
+			migration.NoTransaction = value.Name == "true"
+			continue
+		}
+		value, _ := kv.Value.(*ast.BasicLit)
+		if key == nil || fields[key.Name] == nil {
+			continue
+		}
+		if value == nil || value.Kind != token.STRING {
+			return nil, fmt.Errorf("migration field %s must be a string literal", key.Name)
+		}
+		unquoted, err := strconv.Unquote(value.Value)
+		if err != nil {
+			return nil, err
+		}
+		*fields[key.Name] = unquoted
+	}
+
+	if migration.Version == "" {
+		return nil, fmt.Errorf("migration without a version")
+	}
+	return migration, nil
+}
+
+// MigrationWriter writes versioned migration files into a directory
+type MigrationWriter struct {
+	dir    string
+	format string // "sql" or "go"
+}
+
+// NewMigrationWriter creates a writer for the given directory and file
+// format, "sql" or "go"
+func NewMigrationWriter(dir, format string) (*MigrationWriter, error) {
+	if format != "sql" && format != "go" {
+		return nil, fmt.Errorf("unknown migration format %q, expected sql or go", format)
+	}
+	return &MigrationWriter{dir: dir, format: format}, nil
+}
+
+// Write writes a migration with the given steps and returns the path of
+// the migration file. It is versioned by the current time, or one second
+// after the latest migration in the directory if that is later. If any
+// step cannot run in a transaction, such as CREATE INDEX CONCURRENTLY, the
+// migration is marked NoTransaction and its statements run one by one, so
+// it is still applied and reverted as one. SQL migrations are registered
+// by a migrations.go file embedding them, created if missing.
+func (w *MigrationWriter) Write(name string, up, down []apolon.MigrationStep) (string, error) {
+	base := migrationName(name)
+	if base == "" {
+		return "", fmt.Errorf("invalid migration name %q", name)
+	}
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating migrations directory: %w", err)
+	}
+	next, err := w.nextVersion(time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+
+	path, err := w.writeMigration(apolon.Migration{
+		Version:       next.Format(versionLayout),
+		Name:          base,
+		Up:            renderSteps(up),
+		Down:          renderSteps(down),
+		NoTransaction: hasNonTransactional(up) || hasNonTransactional(down),
+	})
+	if err != nil {
+		return "", err
+	}
+	if w.format == "sql" {
+		return path, w.writeEmbed()
+	}
+	return path, nil
+}
+
+// versionLayout formats migration versions as UTC timestamps
+const versionLayout = "20060102150405"
+
+// nextVersion returns now, or one second after the latest timestamp
+// version in the directory if that is not earlier, so new migrations
+// always sort after existing ones
+func (w *MigrationWriter) nextVersion(now time.Time) (time.Time, error) {
+	existing, err := LoadMigrations(w.dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	next := now.Truncate(time.Second)
+	for _, migration := range existing {
+		version, err := time.Parse(versionLayout, migration.Version)
+		if err != nil {
+			continue
+		}
+		if !version.Before(next) {
+			next = version.Add(time.Second)
+		}
+	}
+	return next, nil
+}
+
+// writeMigration writes one migration file and returns its path
+func (w *MigrationWriter) writeMigration(migration apolon.Migration) (string, error) {
+	base := filepath.Join(w.dir, migration.Version+"_"+migration.Name)
+	if w.format == "go" {
+		return base + ".go", w.writeGo(base+".go", migration)
+	}
+
+	content := "-- +apolon Up\n" + migration.Up + "\n\n-- +apolon Down\n" + migration.Down + "\n"
+	if migration.NoTransaction {
+		content = "-- +apolon NoTransaction\n" + content
+	}
+	if err := os.WriteFile(base+".sql", []byte(content), 0644); err != nil {
+		return "", fmt.Errorf("failed to write migration: %w", err)
+	}
+	return base + ".sql", nil
+}
+
+// writeGo writes a migration as a Go file registering it in init
+func (w *MigrationWriter) writeGo(path string, migration apolon.Migration) error {
+	return w.execute(path, goMigrationTemplate, map[string]any{
+		"Package":   w.packageName(),
+		"Migration": migration,
+	})
+}
+
+// writeEmbed writes the migrations.go file registering the embedded SQL
+// migrations, unless it already exists
+func (w *MigrationWriter) writeEmbed() error {
+	path := filepath.Join(w.dir, "migrations.go")
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return w.execute(path, embedTemplate, map[string]any{"Package": w.packageName()})
+}
+
+// execute renders a Go source template to path
+func (w *MigrationWriter) execute(path string, tmpl *template.Template, data any) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format generated code: %w", err)
+	}
+
+	if err := os.WriteFile(path, src, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// packageName derives the Go package name of the migrations directory
+func (w *MigrationWriter) packageName() string {
+	name := migrationName(filepath.Base(w.dir))
+	if name == "" || unicode.IsDigit(rune(name[0])) || token.IsKeyword(name) {
+		return "migrations"
+	}
+	return strings.ReplaceAll(name, "_", "")
+}
+
+// migrationName reduces a name to lowercase letters, digits and underscores
+func migrationName(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		case sb.Len() > 0 && !strings.HasSuffix(sb.String(), "_"):
+			sb.WriteByte('_')
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "_")
+}
+
+// hasNonTransactional reports whether any step must run outside a transaction
+func hasNonTransactional(steps []apolon.MigrationStep) bool {
+	for _, step := range steps {
+		if step.NonTransactional {
+			return true
+		}
+	}
+	return false
+}
+
+// renderSteps renders steps as SQL statements, each preceded by its
+// description
+func renderSteps(steps []apolon.MigrationStep) string {
+	var sb strings.Builder
+	for i, step := range steps {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString("-- " + step.Description)
+		if step.Destructive {
+			sb.WriteString(" (destructive)")
+		}
+		sb.WriteString("\n" + step.SQL + ";")
+	}
+	return sb.String()
+}
+
+// goString quotes s as a Go raw string literal where possible
+func goString(s string) string {
+	if strings.Contains(s, "`") {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
+
+var goMigrationTemplate = template.Must(template.New("migration").Funcs(template.FuncMap{
+	"goString": goString,
+}).Parse(`// Code generated by apolon migrations add.
+// Edit with care: applied migrations must not change.
+
+package {{ .Package }}
+
+import "github.com/jkeresman01/apolon/apolon"
+
+func init() {
+	apolon.RegisterMigration(apolon.Migration{
+		Version: "{{ .Migration.Version }}",
+		Name:    "{{ .Migration.Name }}",
+		Up: {{ goString .Migration.Up }},
+		Down: {{ goString .Migration.Down }},
+		{{- if .Migration.NoTransaction }}
+		NoTransaction: true,
+		{{- end }}
+	})
+}
+`))
+
+var embedTemplate = template.Must(template.New("embed").Parse(`// Code generated by apolon migrations add. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	"embed"
+
+	"github.com/jkeresman01/apolon/apolon"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+func init() {
+	if err := apolon.RegisterMigrations(files); err != nil {
+		panic(err)
+	}
+}
+`))
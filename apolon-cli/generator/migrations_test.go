@@ -0,0 +1,95 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jkeresman01/apolon/apolon"
+)
+
+func TestMigrationWriterKeepsNonTransactionalStepsInOneMigration(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewMigrationWriter(dir, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	up := []apolon.MigrationStep{
+		{SQL: "CREATE TABLE visits (id SERIAL PRIMARY KEY)", Description: "Create table visits"},
+		{SQL: "CREATE INDEX CONCURRENTLY idx_visits_id ON visits (id)", Description: "Create index", NonTransactional: true},
+	}
+	down := []apolon.MigrationStep{
+		{SQL: "DROP INDEX CONCURRENTLY idx_visits_id", Description: "Drop index", NonTransactional: true},
+		{SQL: "DROP TABLE visits", Description: "Drop table visits", Destructive: true},
+	}
+	if _, err := w.Write("add visits", up, down); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write("add notes", []apolon.MigrationStep{{SQL: "ALTER TABLE visits ADD COLUMN notes TEXT", Description: "Add column"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("wrote %d migrations, want 2", len(migrations))
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	// Reverting the one migration runs both Down steps in order
+	visits := migrations[0]
+	if visits.Name != "add_visits" || !visits.NoTransaction {
+		t.Errorf("migration %s NoTransaction = %v, want add_visits marked NoTransaction", visits.Name, visits.NoTransaction)
+	}
+	if !strings.Contains(visits.Up, "CREATE TABLE visits") || !strings.Contains(visits.Up, "CREATE INDEX CONCURRENTLY") {
+		t.Errorf("Up = %q, want both steps", visits.Up)
+	}
+	dropIndex, dropTable := strings.Index(visits.Down, "DROP INDEX CONCURRENTLY"), strings.Index(visits.Down, "DROP TABLE visits")
+	if dropIndex == -1 || dropTable == -1 || dropIndex > dropTable {
+		t.Errorf("Down = %q, want the index dropped before the table", visits.Down)
+	}
+	if migrations[1].NoTransaction {
+		t.Error("migration without non-transactional steps is marked NoTransaction")
+	}
+}
+
+func TestMigrationWriterVersionsAfterLatest(t *testing.T) {
+	dir := t.TempDir()
+	future := time.Now().UTC().Add(time.Hour).Format(versionLayout)
+	if err := os.WriteFile(filepath.Join(dir, future+"_later.sql"), []byte("-- +apolon Up\nSELECT 1;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewMigrationWriter(dir, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := []apolon.MigrationStep{{SQL: "SELECT 2", Description: "Select"}}
+	for _, name := range []string{"first", "second"} {
+		if _, err := w.Write(name, step, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := make(map[string]string)
+	for _, m := range migrations {
+		if other, ok := versions[m.Version]; ok {
+			t.Errorf("%s and %s share version %s", other, m.Name, m.Version)
+		}
+		versions[m.Version] = m.Name
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	if last := migrations[len(migrations)-1].Name; last != "second" {
+		t.Errorf("latest migration = %s, want second", last)
+	}
+}
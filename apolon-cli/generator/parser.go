@@ -1,39 +1,57 @@
 package generator
 
 import (
+	"fmt"
 	"go/ast"
+	"go/importer"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
 )
 
 // Parser handles AST parsing of Go source files
 type Parser struct {
-	inputDir string
+	inputDir   string
+	naming     shared.NamingStrategy
+	fset       *token.FileSet
+	structs    map[string]*ast.StructType // struct types declared in the package being parsed
+	components map[string]bool            // structs embedded in or owned by other structs
+	tableNames map[string]string          // TableName() overrides keyed by type name
+	pkg        *types.Package             // type-checked package being parsed
+	info       *types.Info                // objects defined by the package being parsed
+	typeErr    error                      // first type-check error of the package
 }
 
-// NewParser creates a new parser for the given directory
-func NewParser(inputDir string) *Parser {
-	return &Parser{inputDir: inputDir}
+// NewParser creates a new parser for the given directory that names
+// untagged tables and columns with the given strategy
+func NewParser(inputDir string, naming shared.NamingStrategy) *Parser {
+	return &Parser{inputDir: inputDir, naming: naming}
 }
 
 // Parse parses all Go files in the directory and returns models grouped by source file
 func (p *Parser) Parse() (map[string][]ModelInfo, error) {
-	fset := token.NewFileSet()
-
-	pkgs, err := parser.ParseDir(fset, p.inputDir, func(fi os.FileInfo) bool {
-		return !strings.HasSuffix(fi.Name(), "_fields.go") &&
-			!strings.HasSuffix(fi.Name(), "_test.go")
-	}, parser.ParseComments)
+	pkgs, err := p.parseDir()
 	if err != nil {
 		return nil, err
 	}
 
 	result := make(map[string][]ModelInfo)
 	for _, pkg := range pkgs {
+		if err := p.loadPackage(pkg); err != nil {
+			return nil, err
+		}
 		for filename, file := range pkg.Files {
-			models := p.extractModels(file, pkg.Name)
+			models, err := p.extractModels(file, pkg.Name)
+			if err != nil {
+				return nil, err
+			}
 			if len(models) > 0 {
 				result[filename] = models
 			}
@@ -43,14 +61,213 @@ func (p *Parser) Parse() (map[string][]ModelInfo, error) {
 	return result, nil
 }
 
+// parseDir parses the Go files of the input directory, skipping generated
+// field accessors and tests
+func (p *Parser) parseDir() (map[string]*ast.Package, error) {
+	p.fset = token.NewFileSet()
+
+	return parser.ParseDir(p.fset, p.inputDir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_fields.go") &&
+			!strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+}
+
+// loadPackage collects the type declarations of a package and type-checks it
+func (p *Parser) loadPackage(pkg *ast.Package) error {
+	p.structs = collectStructs(pkg)
+
+	var err error
+	if p.tableNames, err = collectTableNames(pkg); err != nil {
+		return err
+	}
+	p.components = p.collectComponents()
+	p.checkTypes(pkg)
+	return nil
+}
+
+// checkTypes type-checks a package, importing its dependencies from
+// source, so fields are mapped by their actual types even when declared in
+// other packages. Errors do not stop the check; a field whose type cannot
+// be resolved is reported when it is mapped.
+func (p *Parser) checkTypes(pkg *ast.Package) {
+	names := make([]string, 0, len(pkg.Files))
+	for name := range pkg.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	files := make([]*ast.File, len(names))
+	for i, name := range names {
+		files[i] = pkg.Files[name]
+	}
+
+	p.typeErr = nil
+	p.info = &types.Info{Defs: make(map[*ast.Ident]types.Object)}
+	conf := types.Config{
+		Importer: importer.ForCompiler(p.fset, "source", nil),
+		Error: func(err error) {
+			if p.typeErr == nil {
+				p.typeErr = err
+			}
+		},
+	}
+	p.pkg, _ = conf.Check(pkg.Name, p.fset, files, p.info)
+}
+
+// collectStructs returns the struct types declared in a package by name
+func collectStructs(pkg *ast.Package) map[string]*ast.StructType {
+	structs := make(map[string]*ast.StructType)
+	for _, file := range pkg.Files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if typeSpec, ok := n.(*ast.TypeSpec); ok {
+				if structType, isStruct := typeSpec.Type.(*ast.StructType); isStruct {
+					structs[typeSpec.Name.Name] = structType
+				}
+			}
+			return true
+		})
+	}
+	return structs
+}
+
+// collectComponents returns the names of structs that map to columns of
+// other structs of the package instead of tables of their own. A struct
+// held by value is a component unless it has its own table or key: a
+// TableName() method, a `_` marker field, a pk tag or an ID field. An
+// embedded struct, typically a base type carrying the key, is a component
+// unless it has its own table.
+func (p *Parser) collectComponents() map[string]bool {
+	components := make(map[string]bool)
+	for _, structType := range p.structs {
+		for _, field := range structType.Fields.List {
+			ident, ok := field.Type.(*ast.Ident)
+			if !ok || p.structs[ident.Name] == nil {
+				continue
+			}
+			hasTable, hasKey := p.ownMapping(ident.Name)
+			if len(field.Names) == 0 && !hasTable || len(field.Names) > 0 && !hasTable && !hasKey {
+				components[ident.Name] = true
+			}
+		}
+	}
+	return components
+}
+
+// ownMapping reports whether a struct of the package declares its own
+// table, with a TableName() method or a `_` marker field, and its own key,
+// with a pk tag or a field named ID, possibly of an embedded base type
+func (p *Parser) ownMapping(name string) (hasTable, hasKey bool) {
+	_, hasTable = p.tableNames[name]
+	for _, field := range p.structs[name].Fields.List {
+		tag, _ := apolonTag(field)
+		if ident, ok := field.Type.(*ast.Ident); ok && len(field.Names) == 0 && p.structs[ident.Name] != nil {
+			_, embeddedKey := p.ownMapping(ident.Name)
+			hasKey = hasKey || embeddedKey
+		}
+		for _, ident := range field.Names {
+			switch ident.Name {
+			case "_":
+				hasTable = true
+			case "ID":
+				hasKey = true
+			}
+		}
+		for _, opt := range strings.Split(tag, ",")[1:] {
+			if strings.TrimSpace(opt) == "pk" {
+				hasKey = true
+			}
+		}
+	}
+	return hasTable, hasKey
+}
+
+// collectTableNames returns the string literals returned by TableName()
+// methods declared in a package, keyed by receiver type name. A TableName()
+// method of a struct with any other body cannot be evaluated without
+// running it and is reported as an error.
+func collectTableNames(pkg *ast.Package) (map[string]string, error) {
+	names := make(map[string]string)
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Name.Name != "TableName" || fn.Recv == nil || len(fn.Recv.List) != 1 || fn.Body == nil {
+				continue
+			}
+
+			recv := fn.Recv.List[0].Type
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv = star.X
+			}
+			ident, ok := recv.(*ast.Ident)
+			if !ok {
+				continue
+			}
+
+			name, ok := literalReturn(fn.Body)
+			if !ok {
+				return nil, fmt.Errorf("%s.TableName() must return a single string literal to be read by apolon; "+
+					"use the table option of the `_` marker field instead", ident.Name)
+			}
+			names[ident.Name] = name
+		}
+	}
+	return names, nil
+}
+
+// literalReturn returns the string of a function body consisting of a
+// single return of a string literal
+func literalReturn(body *ast.BlockStmt) (string, bool) {
+	if len(body.List) != 1 {
+		return "", false
+	}
+	ret, ok := body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return "", false
+	}
+	lit, ok := ret.Results[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	name, err := strconv.Unquote(lit.Value)
+	return name, err == nil
+}
+
+// tableName resolves a model's table name from its TableName() method,
+// its `_` marker field, or the default convention
+func (p *Parser) tableName(typeName string, structType *ast.StructType) string {
+	if name, ok := p.tableNames[typeName]; ok && name != "" {
+		return name
+	}
+
+	markerTag := ""
+	for _, field := range structType.Fields.List {
+		if len(field.Names) == 1 && field.Names[0].Name == "_" {
+			markerTag, _ = apolonTag(field)
+		}
+	}
+	return shared.ResolveTableName(typeName, markerTag, p.naming)
+}
+
+// apolonTag returns the value of a field's apolon struct tag
+func apolonTag(field *ast.Field) (string, bool) {
+	if field.Tag == nil {
+		return "", false
+	}
+	raw, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return "", false
+	}
+	return reflect.StructTag(raw).Lookup("apolon")
+}
+
 // extractModels extracts model information from an AST file
-func (p *Parser) extractModels(file *ast.File, pkgName string) []ModelInfo {
+func (p *Parser) extractModels(file *ast.File, pkgName string) ([]ModelInfo, error) {
 	var models []ModelInfo
+	var err error
 
 	ast.Inspect(file, func(n ast.Node) bool {
 		typeSpec, ok := n.(*ast.TypeSpec)
-		if !ok {
-			return true
+		if !ok || err != nil {
+			return err == nil
 		}
 
 		structType, ok := typeSpec.Type.(*ast.StructType)
@@ -58,38 +275,173 @@ func (p *Parser) extractModels(file *ast.File, pkgName string) []ModelInfo {
 			return true
 		}
 
-		if !p.hasDbTag(structType) {
+		if !p.hasDbTag(structType) || p.components[typeSpec.Name.Name] {
 			return true
 		}
 
 		model := ModelInfo{
 			Name:    typeSpec.Name.Name,
-			Table:   strings.ToLower(typeSpec.Name.Name) + "s",
+			Table:   p.tableName(typeSpec.Name.Name, structType),
 			Package: pkgName,
 		}
+		model.Fields, err = p.collectFields(p.structOf(typeSpec), "", "", &model)
+		if err != nil {
+			err = fmt.Errorf("model %s: %w", model.Name, err)
+			return false
+		}
 
-		for _, field := range structType.Fields.List {
-			if len(field.Names) == 0 {
+		if len(model.Fields) > 0 {
+			models = append(models, model)
+		}
+
+		return true
+	})
+
+	return models, err
+}
+
+// structOf returns the type-checked struct declared by a type spec
+func (p *Parser) structOf(typeSpec *ast.TypeSpec) *types.Struct {
+	if obj := p.info.Defs[typeSpec.Name]; obj != nil {
+		if st, ok := obj.Type().Underlying().(*types.Struct); ok {
+			return st
+		}
+	}
+	return types.NewStruct(nil, nil)
+}
+
+// collectFields returns the typed accessors for the fields of a struct and
+// adds every mapped field to the model's properties. Embedded structs are
+// flattened; owned value types are nested and their columns prefixed, e.g.
+// Address.City -> address_city. Both may come from other packages.
+func (p *Parser) collectFields(st *types.Struct, namePrefix, columnPrefix string, model *ModelInfo) ([]FieldInfo, error) {
+	var fields []FieldInfo
+
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		tag, _ := reflect.StructTag(st.Tag(i)).Lookup("apolon")
+		if !field.Exported() || tag == "-" {
+			continue
+		}
+		if !isResolved(field.Type()) {
+			return nil, fmt.Errorf("field %s: cannot resolve its type: %v", namePrefix+field.Name(), p.typeErr)
+		}
+
+		if owned := ownedStruct(field.Type()); owned != nil {
+			if field.Embedded() {
+				nested, err := p.collectFields(owned, namePrefix, columnPrefix, model)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, nested...)
 				continue
 			}
 
-			fieldInfo := p.parseField(field)
-			if fieldInfo != nil {
-				model.Fields = append(model.Fields, *fieldInfo)
-				if fieldInfo.FieldType == "TimeField" {
-					model.HasTimeImport = true
-				}
+			prefix := strings.Split(tag, ",")[0]
+			if prefix == "" {
+				prefix = p.naming.ColumnName(field.Name())
+			}
+			nested, err := p.collectFields(owned, namePrefix+field.Name()+".", columnPrefix+prefix+"_", model)
+			if err != nil {
+				return nil, err
 			}
+			if len(nested) > 0 {
+				fields = append(fields, FieldInfo{Name: field.Name(), Nested: nested})
+			}
+			continue
 		}
 
-		if len(model.Fields) > 0 {
-			models = append(models, model)
+		fieldInfo := p.parseField(field, tag)
+		if fieldInfo == nil {
+			continue
+		}
+		fieldInfo.Column = columnPrefix + fieldInfo.Column
+
+		property := *fieldInfo
+		property.Name = namePrefix + fieldInfo.Name
+		model.Properties = append(model.Properties, property)
+
+		if fieldInfo.FieldType != "" {
+			fields = append(fields, *fieldInfo)
+			if fieldInfo.FieldType == "TimeField" {
+				model.HasTimeImport = true
+			}
 		}
+	}
+
+	return fields, nil
+}
+
+// isResolved reports whether the type checker resolved a type, including
+// its element types
+func isResolved(t types.Type) bool {
+	switch t := t.(type) {
+	case *types.Basic:
+		return t.Kind() != types.Invalid
+	case *types.Pointer:
+		return isResolved(t.Elem())
+	case *types.Slice:
+		return isResolved(t.Elem())
+	case *types.Array:
+		return isResolved(t.Elem())
+	case *types.Map:
+		return isResolved(t.Key()) && isResolved(t.Elem())
+	}
+	return true
+}
+
+// ownedStruct returns the struct of a value type mapped to several columns,
+// or nil for single-column types such as time.Time, sql.NullString or any
+// other type with Scan or Value methods, as decided at runtime
+func ownedStruct(t types.Type) *types.Struct {
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok || isTime(t) || hasSQLMethods(t) {
+		return nil
+	}
+	return st
+}
 
+// hasSQLMethods reports whether t has a Value method or *t a Scan method,
+// the methods of driver.Valuer and sql.Scanner
+func hasSQLMethods(t types.Type) bool {
+	t = types.Unalias(t)
+	if types.NewMethodSet(t).Lookup(nil, "Value") != nil {
 		return true
-	})
+	}
+	return types.NewMethodSet(types.NewPointer(t)).Lookup(nil, "Scan") != nil
+}
 
-	return models
+// isTime reports whether t is time.Time
+func isTime(t types.Type) bool {
+	named, ok := types.Unalias(t).(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "time" && named.Obj().Name() == "Time"
+}
+
+// isNavigationType reports whether a field type is *T, []T or []*T of a
+// struct type other than time.Time and SQL value types, like
+// shared.NavigationTarget
+func isNavigationType(t types.Type) bool {
+	switch u := t.(type) {
+	case *types.Pointer:
+		t = u.Elem()
+	case *types.Slice:
+		t = u.Elem()
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+	default:
+		return false
+	}
+	_, isStruct := t.Underlying().(*types.Struct)
+	return isStruct && !isTime(t) && !hasSQLMethods(t)
+}
+
+// qualifier names packages other than the parsed one in Go type strings
+func (p *Parser) qualifier(pkg *types.Package) string {
+	if pkg == p.pkg {
+		return ""
+	}
+	return pkg.Name()
 }
 
 // hasDbTag checks if any field in the struct has a db tag
@@ -102,68 +454,57 @@ func (p *Parser) hasDbTag(structType *ast.StructType) bool {
 	return false
 }
 
-// parseField extracts field information from an AST field
-func (p *Parser) parseField(field *ast.Field) *FieldInfo {
-	if len(field.Names) == 0 {
-		return nil
-	}
-
-	name := field.Names[0].Name
-	goType := p.typeToString(field.Type)
-	column := strings.ToLower(name)
+// parseField extracts field information from a struct field with the
+// given apolon tag. Fields without a typed accessor are returned with an
+// empty FieldType.
+func (p *Parser) parseField(field *types.Var, tag string) *FieldInfo {
+	name := field.Name()
+	goType := types.TypeString(field.Type(), p.qualifier)
+	column := p.naming.ColumnName(name)
+	explicitColumn := false
 	isPK := false
 
-	if field.Tag != nil {
-		tag := strings.Trim(field.Tag.Value, "`")
-
-		for _, part := range strings.Split(tag, " ") {
-			if strings.HasPrefix(part, `apolon:"`) {
-				value := strings.TrimPrefix(part, `apolon:"`)
-				value = strings.TrimSuffix(value, `"`)
-
-				parts := strings.Split(value, ",")
-				if len(parts) > 0 && parts[0] != "" {
-					column = parts[0]
-				}
+	if tag != "" {
+		parts := strings.Split(tag, ",")
+		if parts[0] != "" {
+			column = parts[0]
+			explicitColumn = true
+		}
 
-				for _, opt := range parts[1:] {
-					if opt == "pk" {
-						isPK = true
-					}
-				}
+		for _, opt := range parts[1:] {
+			if strings.TrimSpace(opt) == "pk" {
+				isPK = true
 			}
 		}
 	}
 
-	fieldType := p.goTypeToFieldType(goType)
-	if fieldType == "" {
+	// Navigations to other entities are not columns
+	if !explicitColumn && isNavigationType(field.Type()) {
 		return nil
 	}
 
 	return &FieldInfo{
-		Name:      name,
-		Column:    column,
-		FieldType: fieldType,
-		GoType:    goType,
-		IsPK:      isPK,
+		Name:       name,
+		Column:     column,
+		FieldType:  p.goTypeToFieldType(goType),
+		GoType:     goType,
+		IsPK:       isPK,
+		Comparable: p.isComparable(goType),
 	}
 }
 
-// typeToString converts an AST type to a string representation
-func (p *Parser) typeToString(expr ast.Expr) string {
-	switch t := expr.(type) {
-	case *ast.Ident:
-		return t.Name
-	case *ast.SelectorExpr:
-		if x, ok := t.X.(*ast.Ident); ok {
-			return x.Name + "." + t.Sel.Name
-		}
-	case *ast.StarExpr:
-		return "*" + p.typeToString(t.X)
-	case *ast.ArrayType:
-		return "[]" + p.typeToString(t.Elt)
+// isComparable reports whether values of a Go type can be compared with ==
+// with the same result as reflect.DeepEqual
+func (p *Parser) isComparable(goType string) bool {
+	switch goType {
+	case "int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64",
+		"byte", "rune", "string", "bool", "float32", "float64",
+		"time.Time":
+		return true
+	default:
+		return false
 	}
-	return ""
 }
 
 // goTypeToFieldType maps Go types to ORM field types
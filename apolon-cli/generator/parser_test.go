@@ -0,0 +1,153 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// writePackage writes Go source files into a temporary directory and
+// returns a parser for it
+func writePackage(t *testing.T, files map[string]string) *Parser {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewParser(dir, shared.DefaultNamingStrategy{})
+}
+
+func TestTableNameMethods(t *testing.T) {
+	p := writePackage(t, map[string]string{"model.go": `package models
+
+type Patient struct {
+	ID int ` + "`apolon:\"id,pk\"`" + `
+}
+
+func (Patient) TableName() string { return "clinical.patients" }
+`})
+
+	schemas, err := p.ParseSchemas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schemas) != 1 || schemas[0].Table != "clinical.patients" {
+		t.Errorf("tables = %v, want clinical.patients", schemas)
+	}
+}
+
+func TestTableNameNotLiteral(t *testing.T) {
+	p := writePackage(t, map[string]string{"model.go": `package models
+
+const prefix = "app_"
+
+type Patient struct {
+	ID int ` + "`apolon:\"id,pk\"`" + `
+}
+
+func (Patient) TableName() string { return prefix + "patients" }
+`})
+
+	_, err := p.ParseSchemas()
+	if err == nil || !strings.Contains(err.Error(), "Patient.TableName()") {
+		t.Errorf("ParseSchemas() error = %v, want a TableName error", err)
+	}
+	if _, err := p.Parse(); err == nil {
+		t.Error("Parse() accepted a TableName() it cannot evaluate")
+	}
+}
+
+// modelsByName parses a package and returns its models by name
+func modelsByName(t *testing.T, p *Parser) map[string]ModelInfo {
+	t.Helper()
+	files, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	models := make(map[string]ModelInfo)
+	for _, fileModels := range files {
+		for _, model := range fileModels {
+			models[model.Name] = model
+		}
+	}
+	return models
+}
+
+// columns returns the columns of a model's properties
+func columns(model ModelInfo) []string {
+	var cols []string
+	for _, property := range model.Properties {
+		cols = append(cols, property.Column)
+	}
+	return cols
+}
+
+func TestComponents(t *testing.T) {
+	p := writePackage(t, map[string]string{"model.go": `package models
+
+import "image"
+
+type Base struct {
+	ID int ` + "`apolon:\"id,pk\"`" + `
+}
+
+type Address struct {
+	City string ` + "`apolon:\"city\"`" + `
+}
+
+type Patient struct {
+	Base
+	image.Point
+	Name    string  ` + "`apolon:\"name\"`" + `
+	Address Address
+}
+
+type PatientView struct {
+	Patient Patient
+	Visits  int ` + "`apolon:\"visits\"`" + `
+}
+`})
+
+	models := modelsByName(t, p)
+	if _, ok := models["Base"]; ok {
+		t.Error("embedded base type Base was generated as a model")
+	}
+	if _, ok := models["Address"]; ok {
+		t.Error("owned type Address was generated as a model")
+	}
+
+	patient, ok := models["Patient"]
+	if !ok {
+		t.Fatal("Patient reused by value in PatientView is no longer a model")
+	}
+	if got, want := strings.Join(columns(patient), ","), "id,x,y,name,address_city"; got != want {
+		t.Errorf("Patient columns = %s, want %s", got, want)
+	}
+
+	view := models["PatientView"]
+	if got, want := strings.Join(columns(view), ","), "patient_id,patient_x,patient_y,patient_name,patient_address_city,visits"; got != want {
+		t.Errorf("PatientView columns = %s, want %s", got, want)
+	}
+}
+
+func TestUnresolvedFieldType(t *testing.T) {
+	p := writePackage(t, map[string]string{"model.go": `package models
+
+import "example.com/missing/audit"
+
+type Patient struct {
+	ID int ` + "`apolon:\"id,pk\"`" + `
+	audit.Fields
+}
+`})
+
+	_, err := p.Parse()
+	if err == nil || !strings.Contains(err.Error(), "Patient") {
+		t.Errorf("Parse() error = %v, want an unresolved type error", err)
+	}
+}
@@ -0,0 +1,262 @@
+package generator
+
+import (
+	"database/sql"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// ParseSchemas parses all Go files in the directory and returns the table
+// definitions of the models sorted by table name. Models are mapped with
+// the same tag rules and field types as at runtime, resolved by
+// type-checking the package; a field whose type cannot be resolved is an
+// error. Mappings configured in code through OnModelCreating and foreign
+// keys inferred from navigations are not seen.
+func (p *Parser) ParseSchemas() ([]*shared.SchemaInfo, error) {
+	pkgs, err := p.parseDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var schemas []*shared.SchemaInfo
+	for _, pkg := range pkgs {
+		if err := p.loadPackage(pkg); err != nil {
+			return nil, err
+		}
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				schema, err := p.declSchemas(decl)
+				if err != nil {
+					return nil, err
+				}
+				schemas = append(schemas, schema...)
+			}
+		}
+	}
+
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Table < schemas[j].Table })
+	return schemas, nil
+}
+
+// declSchemas returns the table definitions of the models declared by decl
+func (p *Parser) declSchemas(decl ast.Decl) ([]*shared.SchemaInfo, error) {
+	var schemas []*shared.SchemaInfo
+	var err error
+
+	ast.Inspect(decl, func(n ast.Node) bool {
+		typeSpec, ok := n.(*ast.TypeSpec)
+		if !ok || err != nil {
+			return err == nil
+		}
+		structType, ok := typeSpec.Type.(*ast.StructType)
+		if !ok || !p.hasDbTag(structType) || p.components[typeSpec.Name.Name] {
+			return true
+		}
+
+		var schema *shared.SchemaInfo
+		schema, err = p.modelSchema(typeSpec, structType)
+		if schema != nil {
+			schemas = append(schemas, schema)
+		}
+		return false
+	})
+
+	return schemas, err
+}
+
+// modelSchema builds the table definition of a model. The struct is
+// rebuilt with reflect.StructOf from its type-checked Go types and mapped
+// by shared.BuildModel, so tags are interpreted exactly as at runtime.
+func (p *Parser) modelSchema(typeSpec *ast.TypeSpec, structType *ast.StructType) (*shared.SchemaInfo, error) {
+	name := typeSpec.Name.Name
+	fields, err := p.structFields(p.structOf(typeSpec), "")
+	if err != nil {
+		return nil, fmt.Errorf("model %s: %w", name, err)
+	}
+	t := reflect.StructOf(fields)
+
+	config := shared.NewEntityConfig(t)
+	config.Table = p.tableName(name, structType)
+
+	model, err := shared.BuildModel(t, p.naming, config)
+	if err != nil {
+		return nil, fmt.Errorf("model %s: %w", name, err)
+	}
+	return model.SchemaInfo(), nil
+}
+
+// structFields returns reflect fields for the exported fields of a struct.
+// Embedded owned structs are flattened into the fields of their owner,
+// with their names prefixed by namePrefix in errors.
+func (p *Parser) structFields(st *types.Struct, namePrefix string) ([]reflect.StructField, error) {
+	var fields []reflect.StructField
+	seen := make(map[string]bool)
+
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		tag := reflect.StructTag(st.Tag(i))
+		if !field.Exported() || (!field.Embedded() && tag.Get("apolon") == "-") {
+			continue
+		}
+
+		if owned := ownedStruct(field.Type()); owned != nil && field.Embedded() && tag.Get("apolon") != "-" {
+			embedded, err := p.structFields(owned, namePrefix+field.Name()+".")
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range embedded {
+				// Fields of the owner shadow promoted fields
+				if !seen[f.Name] {
+					seen[f.Name] = true
+					fields = append(fields, f)
+				}
+			}
+			continue
+		}
+
+		fieldType, err := p.reflectType(field.Type())
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", namePrefix+field.Name(), err)
+		}
+		if seen[field.Name()] {
+			for j := range fields {
+				if fields[j].Name == field.Name() {
+					fields = append(fields[:j], fields[j+1:]...)
+					break
+				}
+			}
+		}
+		seen[field.Name()] = true
+		fields = append(fields, reflect.StructField{Name: field.Name(), Type: fieldType, Tag: tag})
+	}
+
+	return fields, nil
+}
+
+// knownTypes maps types from the standard library that have Scan or Value
+// methods, by package path and name, to their reflect types
+var knownTypes = map[string]reflect.Type{
+	"time.Time":                reflect.TypeOf(time.Time{}),
+	"database/sql.NullString":  reflect.TypeOf(sql.NullString{}),
+	"database/sql.NullInt16":   reflect.TypeOf(sql.NullInt16{}),
+	"database/sql.NullInt32":   reflect.TypeOf(sql.NullInt32{}),
+	"database/sql.NullInt64":   reflect.TypeOf(sql.NullInt64{}),
+	"database/sql.NullFloat64": reflect.TypeOf(sql.NullFloat64{}),
+	"database/sql.NullBool":    reflect.TypeOf(sql.NullBool{}),
+	"database/sql.NullTime":    reflect.TypeOf(sql.NullTime{}),
+	"database/sql.NullByte":    reflect.TypeOf(sql.NullByte{}),
+}
+
+// basicTypes maps basic type kinds to their reflect types
+var basicTypes = map[types.BasicKind]reflect.Type{
+	types.Int:     reflect.TypeOf(int(0)),
+	types.Int8:    reflect.TypeOf(int8(0)),
+	types.Int16:   reflect.TypeOf(int16(0)),
+	types.Int32:   reflect.TypeOf(int32(0)),
+	types.Int64:   reflect.TypeOf(int64(0)),
+	types.Uint:    reflect.TypeOf(uint(0)),
+	types.Uint8:   reflect.TypeOf(uint8(0)),
+	types.Uint16:  reflect.TypeOf(uint16(0)),
+	types.Uint32:  reflect.TypeOf(uint32(0)),
+	types.Uint64:  reflect.TypeOf(uint64(0)),
+	types.Float32: reflect.TypeOf(float32(0)),
+	types.Float64: reflect.TypeOf(float64(0)),
+	types.String:  reflect.TypeOf(""),
+	types.Bool:    reflect.TypeOf(false),
+}
+
+var (
+	// sqlValueStruct stands in for struct types with Scan or Value methods,
+	// which map to a single TEXT column
+	sqlValueStruct = reflect.TypeOf(sql.NullString{})
+
+	// navigationTarget stands in for the structs referenced by pointers and
+	// slices, which map like any other struct and could be recursive
+	navigationTarget = reflect.TypeOf(struct{}{})
+)
+
+// reflectType returns a reflect type with the same column mapping as a Go
+// type: the same kind, time.Time and SQL value types kept as such, owned
+// structs rebuilt field by field. Types that cannot be resolved or have no
+// column mapping are reported as errors.
+func (p *Parser) reflectType(t types.Type) (reflect.Type, error) {
+	if named, ok := types.Unalias(t).(*types.Named); ok && named.Obj().Pkg() != nil {
+		if known, ok := knownTypes[named.Obj().Pkg().Path()+"."+named.Obj().Name()]; ok {
+			return known, nil
+		}
+	}
+	if _, isStruct := t.Underlying().(*types.Struct); isStruct && hasSQLMethods(t) {
+		return sqlValueStruct, nil
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if basic, ok := basicTypes[u.Kind()]; ok {
+			return basic, nil
+		}
+		if u.Kind() == types.Invalid {
+			return nil, fmt.Errorf("cannot resolve type %s: %v", types.TypeString(t, p.qualifier), p.typeErr)
+		}
+	case *types.Pointer:
+		elem, err := p.elemType(u.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return reflect.PointerTo(elem), nil
+	case *types.Slice:
+		elem, err := p.elemType(u.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return reflect.SliceOf(elem), nil
+	case *types.Array:
+		elem, err := p.elemType(u.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ArrayOf(int(u.Len()), elem), nil
+	case *types.Map:
+		key, err := p.reflectType(u.Key())
+		if err != nil {
+			return nil, err
+		}
+		elem, err := p.elemType(u.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	case *types.Interface:
+		return reflect.TypeOf((*any)(nil)).Elem(), nil
+	case *types.Struct:
+		fields, err := p.structFields(u, "")
+		if err != nil {
+			return nil, err
+		}
+		return reflect.StructOf(fields), nil
+	}
+	return nil, fmt.Errorf("type %s has no column mapping; tag the field apolon:\"-\"", types.TypeString(t, p.qualifier))
+}
+
+// elemType returns the reflect type of a pointer, slice or map element.
+// Structs other than time.Time and SQL value types are replaced by an empty
+// struct: the field is then a navigation, or a TEXT or JSONB column, as it
+// would be with the full struct.
+func (p *Parser) elemType(t types.Type) (reflect.Type, error) {
+	if ptr, ok := t.(*types.Pointer); ok {
+		elem, err := p.elemType(ptr.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return reflect.PointerTo(elem), nil
+	}
+	if ownedStruct(t) != nil {
+		return navigationTarget, nil
+	}
+	return p.reflectType(t)
+}
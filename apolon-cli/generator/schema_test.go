@@ -0,0 +1,128 @@
+package generator
+
+import (
+	"database/sql"
+	"encoding/json"
+	"image"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// Status is declared in the parsed source too
+type Status string
+
+// typedOther is a navigation target
+type typedOther struct {
+	ID int
+}
+
+// typedModel mirrors the Typed struct of typedSource, so the schema the
+// CLI derives from source can be compared with the runtime one
+type typedModel struct {
+	ID       int64           `apolon:"id,pk"`
+	Name     sql.NullString  `apolon:"name"`
+	Born     *time.Time      `apolon:"born"`
+	Payload  json.RawMessage `apolon:"payload"`
+	Timeout  time.Duration   `apolon:"timeout"`
+	Tags     []string        `apolon:"tags"`
+	Counts   map[string]int  `apolon:"counts"`
+	Status   Status          `apolon:"status,size:20"`
+	Any      any             `apolon:"any"`
+	Position image.Point
+	image.Rectangle
+	Other    *typedOther
+	Others   []typedOther
+	Callback func() `apolon:"-"`
+}
+
+const typedSource = `package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"image"
+	"time"
+)
+
+type Status string
+
+type typedOther struct {
+	ID int
+}
+
+type Typed struct {
+	ID       int64           ` + "`apolon:\"id,pk\"`" + `
+	Name     sql.NullString  ` + "`apolon:\"name\"`" + `
+	Born     *time.Time      ` + "`apolon:\"born\"`" + `
+	Payload  json.RawMessage ` + "`apolon:\"payload\"`" + `
+	Timeout  time.Duration   ` + "`apolon:\"timeout\"`" + `
+	Tags     []string        ` + "`apolon:\"tags\"`" + `
+	Counts   map[string]int  ` + "`apolon:\"counts\"`" + `
+	Status   Status          ` + "`apolon:\"status,size:20\"`" + `
+	Any      any             ` + "`apolon:\"any\"`" + `
+	Position image.Point
+	image.Rectangle
+	Other    *typedOther
+	Others   []typedOther
+	Callback func() ` + "`apolon:\"-\"`" + `
+}
+`
+
+func TestSchemaTypesMatchRuntime(t *testing.T) {
+	p := writePackage(t, map[string]string{"model.go": typedSource})
+	schemas, err := p.ParseSchemas()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(schemas) != 1 {
+		t.Fatalf("parsed %d schemas, want 1", len(schemas))
+	}
+	parsed := schemas[0]
+
+	want := shared.ParseSchema(typedModel{})
+	if len(parsed.Columns) != len(want.Columns) {
+		t.Fatalf("columns = %v, want %v", parsed.Columns, want.Columns)
+	}
+	for i, col := range want.Columns {
+		got := parsed.Columns[i]
+		if got.Name != col.Name || got.SQLType != col.SQLType || got.IsPrimaryKey != col.IsPrimaryKey {
+			t.Errorf("column %d = %s %s, want %s %s", i, got.Name, got.SQLType, col.Name, col.SQLType)
+		}
+	}
+}
+
+func TestSchemaUnmappedTypes(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  string
+	}{
+		{"missing package", "Tags audit.Tags", "cannot resolve type"},
+		{"missing embedded package", "audit.Fields", "cannot resolve type"},
+		{"func", "Hook func()", "no column mapping"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := writePackage(t, map[string]string{"model.go": `package models
+
+import "example.com/missing/audit"
+
+var _ audit.Fields
+
+type Patient struct {
+	ID int ` + "`apolon:\"id,pk\"`" + `
+	` + tt.field + `
+}
+`})
+			_, err := p.ParseSchemas()
+			if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), "Patient") {
+				t.Errorf("ParseSchemas() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -6,14 +6,18 @@ import (
 	"path/filepath"
 
 	"github.com/jkeresman01/apolon/apolon-cli/generator"
+	"github.com/jkeresman01/apolon/apolon-shared"
 	"github.com/spf13/cobra"
 )
 
 const version = "0.1.0"
 
 var (
-	inputDir  string
-	outputDir string
+	inputDir       string
+	outputDir      string
+	naming         string
+	tablePrefix    string
+	singularTables bool
 )
 
 func main() {
@@ -42,7 +46,8 @@ This command parses Go files containing structs with apolon tags and generates
 corresponding *_fields.go files with typed field accessors for queries.`,
 	Example: `  apolon generate
   apolon generate --input ./models --output ./models
-  apolon generate -i ./internal/domain`,
+  apolon generate -i ./internal/domain
+  apolon generate --naming snake --table-prefix app_`,
 	RunE: runGenerate,
 }
 
@@ -57,12 +62,20 @@ var versionCmd = &cobra.Command{
 func init() {
 	generateCmd.Flags().StringVarP(&inputDir, "input", "i", ".", "Input directory containing model files")
 	generateCmd.Flags().StringVarP(&outputDir, "output", "o", "", "Output directory for generated files (default: same as input)")
+	generateCmd.Flags().StringVar(&naming, "naming", "default", "Naming strategy for untagged tables and columns: default, snake or identity")
+	generateCmd.Flags().StringVar(&tablePrefix, "table-prefix", "", "Prefix for derived table names (snake and identity naming)")
+	generateCmd.Flags().BoolVar(&singularTables, "singular-tables", false, "Do not pluralize derived table names (snake naming)")
 
 	rootCmd.AddCommand(generateCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
 func runGenerate(cmd *cobra.Command, args []string) error {
+	namingStrategy, err := shared.NamingStrategyByName(naming, tablePrefix, singularTables)
+	if err != nil {
+		return err
+	}
+
 	// Resolve input directory to absolute path
 	absInput, err := filepath.Abs(inputDir)
 	if err != nil {
@@ -96,7 +109,7 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 	fmt.Printf("  Input:  %s\n", absInput)
 	fmt.Printf("  Output: %s\n", absOutput)
 
-	parser := generator.NewParser(absInput)
+	parser := generator.NewParser(absInput, namingStrategy)
 	models, err := parser.Parse()
 	if err != nil {
 		return fmt.Errorf("failed to parse: %w", err)
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/jkeresman01/apolon/apolon"
+	"github.com/jkeresman01/apolon/apolon-cli/generator"
+	"github.com/jkeresman01/apolon/apolon-shared"
+	"github.com/spf13/cobra"
+)
+
+// dsnEnv names the environment variable read when --dsn is not set
+const dsnEnv = "APOLON_DSN"
+
+var (
+	dsn              string
+	scriptFrom       string
+	scriptTo         string
+	scriptOutput     string
+	allowDestructive bool
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply, revert and inspect versioned migrations",
+	Long: `Apply, revert and inspect the versioned migrations in a directory.
+
+Migrations are read from SQL files and from Go files registering them with
+literal SQL. The database is given with --dsn or the ` + dsnEnv + ` environment
+variable.`,
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply all pending migrations",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		migrator, db, err := openMigrator()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		n, err := migrator.Up(context.Background())
+		fmt.Printf("Applied %d migration(s)\n", n)
+		return err
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down [n]",
+	Short: "Revert the last n applied migrations (default 1)",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		steps := 1
+		if len(args) == 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[0])
+			}
+		}
+
+		migrator, db, err := openMigrator()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		n, err := migrator.Down(context.Background(), steps)
+		fmt.Printf("Reverted %d migration(s)\n", n)
+		return err
+	},
+}
+
+var migrateRedoCmd = &cobra.Command{
+	Use:   "redo",
+	Short: "Revert and reapply the last applied migration",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		migrator, db, err := openMigrator()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return migrator.Redo(context.Background())
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List applied and pending migrations",
+	Long: `List applied and pending migrations.
+
+Exits with a non-zero status if an applied migration was modified or its file
+is missing.`,
+	Args: cobra.NoArgs,
+	RunE: runMigrateStatus,
+}
+
+var migrateScriptCmd = &cobra.Command{
+	Use:   "script",
+	Short: "Print an SQL script applying migrations",
+	Long: `Print an SQL script applying the migrations after --from up to and
+including --to, for review and manual execution. Each migration runs in its
+own transaction that records it in the history table first, so a migration
+already applied fails and rolls back without changes. Run the script with
+psql -v ON_ERROR_STOP=1 to stop at the first failure.
+
+No database connection is needed.`,
+	Example: `  apolon migrate script
+  apolon migrate script --from 20240101120000 --to 20240301090000 -o release.sql`,
+	Args: cobra.NoArgs,
+	RunE: runMigrateScript,
+}
+
+var migratePlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Print the DDL AutoMigrate would run for your models",
+	Long: `Print the DDL statements AutoMigrate would run to bring the database in
+line with the models in the input directory, without changing the database.
+Each statement is marked safe or destructive. Destructive statements are
+commented out as skipped, as AutoMigrate skips them, unless
+--allow-destructive is set.
+
+Mappings configured in code with OnModelCreating are not visible to the CLI,
+so the plan has no seed steps for rows configured with HasData.`,
+	Example: `  apolon migrate plan -i ./models
+  apolon migrate plan -i ./models --naming snake
+  apolon migrate plan -i ./models --allow-destructive`,
+	Args: cobra.NoArgs,
+	RunE: runMigratePlan,
+}
+
+func init() {
+	migrateCmd.PersistentFlags().StringVarP(&migrationsDir, "dir", "d", "migrations", "Directory containing migrations")
+	migrateCmd.PersistentFlags().StringVar(&dsn, "dsn", "", "Database connection string (default $"+dsnEnv+")")
+
+	migrateScriptCmd.Flags().StringVar(&scriptFrom, "from", "", "Version after which to start (default: first migration)")
+	migrateScriptCmd.Flags().StringVar(&scriptTo, "to", "", "Last version to include (default: last migration)")
+	migrateScriptCmd.Flags().StringVarP(&scriptOutput, "output", "o", "", "Write the script to a file instead of stdout")
+
+	migratePlanCmd.Flags().StringVarP(&inputDir, "input", "i", ".", "Input directory containing model files")
+	migratePlanCmd.Flags().StringVar(&naming, "naming", "default", "Naming strategy for untagged tables and columns: default, snake or identity")
+	migratePlanCmd.Flags().StringVar(&tablePrefix, "table-prefix", "", "Prefix for derived table names (snake and identity naming)")
+	migratePlanCmd.Flags().BoolVar(&singularTables, "singular-tables", false, "Do not pluralize derived table names (snake naming)")
+	migratePlanCmd.Flags().BoolVar(&allowDestructive, "allow-destructive", false, "Plan destructive steps as AutoMigrateWith with AllowDestructive runs them")
+
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateRedoCmd, migrateStatusCmd, migrateScriptCmd, migratePlanCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
+
+// openMigrator connects to the database and loads the migrations directory
+func openMigrator() (*apolon.Migrator, *apolon.DB, error) {
+	migrations, err := generator.LoadMigrations(migrationsDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	return apolon.NewMigrator(db, migrations), db, nil
+}
+
+// openDB connects to the database given by --dsn or the environment
+func openDB() (*apolon.DB, error) {
+	if dsn == "" {
+		dsn = os.Getenv(dsnEnv)
+	}
+	if dsn == "" {
+		return nil, fmt.Errorf("no database given, set --dsn or %s", dsnEnv)
+	}
+	db, err := apolon.Open(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	return db, nil
+}
+
+func runMigrateStatus(cmd *cobra.Command, args []string) error {
+	migrator, db, err := openMigrator()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	statuses, err := migrator.Status(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if err := printStatus(os.Stdout, statuses); err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+	return nil
+}
+
+// printStatus lists the migration statuses and returns an error if an
+// applied migration was modified or its file is missing
+func printStatus(w io.Writer, statuses []apolon.MigrationStatus) error {
+	drift := 0
+	for _, status := range statuses {
+		state := "pending"
+		switch {
+		case status.Missing:
+			state = "applied, file missing"
+			drift++
+		case status.Modified:
+			state = "applied, modified"
+			drift++
+		case status.Applied:
+			state = "applied"
+		}
+		fmt.Fprintf(w, "%s  %-40s %s\n", status.Version, status.Name, state)
+	}
+
+	if drift > 0 {
+		return fmt.Errorf("%d applied migration(s) differ from the migrations directory", drift)
+	}
+	return nil
+}
+
+func runMigrateScript(cmd *cobra.Command, args []string) error {
+	migrations, err := generator.LoadMigrations(migrationsDir)
+	if err != nil {
+		return err
+	}
+
+	script := apolon.NewMigrator(nil, migrations).Script(scriptFrom, scriptTo)
+	if scriptOutput == "" {
+		fmt.Print(script)
+		return nil
+	}
+	if err := os.WriteFile(scriptOutput, []byte(script), 0644); err != nil {
+		return fmt.Errorf("failed to write script: %w", err)
+	}
+	fmt.Printf("Wrote %s\n", scriptOutput)
+	return nil
+}
+
+func runMigratePlan(cmd *cobra.Command, args []string) error {
+	namingStrategy, err := shared.NamingStrategyByName(naming, tablePrefix, singularTables)
+	if err != nil {
+		return err
+	}
+	absInput, err := filepath.Abs(inputDir)
+	if err != nil {
+		return fmt.Errorf("error resolving input path: %w", err)
+	}
+	schemas, err := generator.NewParser(absInput, namingStrategy).ParseSchemas()
+	if err != nil {
+		return fmt.Errorf("failed to parse: %w", err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	steps, err := db.SchemaMigrationPlan(apolon.MigrateOptions{AllowDestructive: allowDestructive}, schemas)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		fmt.Println("-- The database is up to date")
+		return nil
+	}
+
+	fmt.Print(apolon.NewMigrationBuilder().BuildPlanSQL(steps))
+
+	destructive, skipped := 0, 0
+	for _, step := range steps {
+		if step.Skipped {
+			skipped++
+		} else if step.Destructive {
+			destructive++
+		}
+	}
+	fmt.Printf("\n-- %d step(s), %d destructive, %d skipped\n", len(steps), destructive, skipped)
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jkeresman01/apolon/apolon"
+)
+
+func TestPrintStatus(t *testing.T) {
+	statuses := []apolon.MigrationStatus{
+		{Version: "1", Name: "create_patients", Applied: true},
+		{Version: "2", Name: "add_email"},
+	}
+	var out bytes.Buffer
+	if err := printStatus(&out, statuses); err != nil {
+		t.Errorf("printStatus() error = %v, want nil without drift", err)
+	}
+	if !strings.Contains(out.String(), "create_patients") || !strings.Contains(out.String(), "pending") {
+		t.Errorf("output = %q", out.String())
+	}
+
+	statuses = append(statuses,
+		apolon.MigrationStatus{Version: "3", Name: "edited", Applied: true, Modified: true},
+		apolon.MigrationStatus{Version: "4", Name: "deleted", Applied: true, Missing: true},
+	)
+	out.Reset()
+	err := printStatus(&out, statuses)
+	if err == nil || !strings.Contains(err.Error(), "2 applied migration(s) differ") {
+		t.Errorf("printStatus() error = %v, want 2 drifted migrations", err)
+	}
+	if !strings.Contains(out.String(), "applied, modified") || !strings.Contains(out.String(), "applied, file missing") {
+		t.Errorf("output = %q", out.String())
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/jkeresman01/apolon/apolon"
+	"github.com/jkeresman01/apolon/apolon-cli/generator"
+	"github.com/jkeresman01/apolon/apolon-shared"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrationsDir   string
+	migrationFormat string
+)
+
+var migrationsCmd = &cobra.Command{
+	Use:   "migrations",
+	Short: "Manage versioned migration files",
+}
+
+var migrationsAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add a migration for model changes since the last migration",
+	Long: `Add a migration for the changes to your models since the last migration.
+
+This command parses the models in the input directory, diffs them against the
+model snapshot stored in the migrations directory and writes a timestamped
+migration with Up and Down steps. The snapshot is then updated; commit it
+together with the migration.
+
+Mappings configured in code with OnModelCreating are not visible to the CLI.
+Seed rows configured with HasData are therefore neither in the snapshot nor in
+the generated migrations; upsert them with DB.SeedData after DB.Migrate.`,
+	Example: `  apolon migrations add create_patients
+  apolon migrations add add_patient_email -i ./models -d ./migrations
+  apolon migrations add add_visits --format go`,
+	Args: cobra.ExactArgs(1),
+	RunE: runMigrationsAdd,
+}
+
+func init() {
+	migrationsAddCmd.Flags().StringVarP(&inputDir, "input", "i", ".", "Input directory containing model files")
+	migrationsAddCmd.Flags().StringVarP(&migrationsDir, "dir", "d", "migrations", "Directory containing migrations and the model snapshot")
+	migrationsAddCmd.Flags().StringVar(&migrationFormat, "format", "sql", "Migration file format: sql or go")
+	migrationsAddCmd.Flags().StringVar(&naming, "naming", "default", "Naming strategy for untagged tables and columns: default, snake or identity")
+	migrationsAddCmd.Flags().StringVar(&tablePrefix, "table-prefix", "", "Prefix for derived table names (snake and identity naming)")
+	migrationsAddCmd.Flags().BoolVar(&singularTables, "singular-tables", false, "Do not pluralize derived table names (snake naming)")
+
+	migrationsCmd.AddCommand(migrationsAddCmd)
+	rootCmd.AddCommand(migrationsCmd)
+}
+
+func runMigrationsAdd(cmd *cobra.Command, args []string) error {
+	namingStrategy, err := shared.NamingStrategyByName(naming, tablePrefix, singularTables)
+	if err != nil {
+		return err
+	}
+
+	absInput, err := filepath.Abs(inputDir)
+	if err != nil {
+		return fmt.Errorf("error resolving input path: %w", err)
+	}
+	absDir, err := filepath.Abs(migrationsDir)
+	if err != nil {
+		return fmt.Errorf("error resolving migrations path: %w", err)
+	}
+
+	writer, err := generator.NewMigrationWriter(absDir, migrationFormat)
+	if err != nil {
+		return err
+	}
+
+	schemas, err := generator.NewParser(absInput, namingStrategy).ParseSchemas()
+	if err != nil {
+		return fmt.Errorf("failed to parse: %w", err)
+	}
+	snapshot, err := generator.LoadSnapshot(absDir)
+	if err != nil {
+		return err
+	}
+
+	up := apolon.DiffSchemas(snapshot.Tables, schemas)
+	if len(up) == 0 {
+		fmt.Println("No model changes since the last migration")
+		return nil
+	}
+	down := apolon.DiffSchemas(schemas, snapshot.Tables)
+
+	path, err := writer.Write(args[0], up, down)
+	if err != nil {
+		return err
+	}
+	if err := generator.SaveSnapshot(absDir, &generator.Snapshot{Tables: schemas}); err != nil {
+		return err
+	}
+
+	fmt.Printf("Added %s\n", path)
+	for _, step := range up {
+		if step.Destructive {
+			fmt.Printf("  warning: %s is destructive\n", step.Description)
+		}
+	}
+	return nil
+}
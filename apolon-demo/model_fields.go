@@ -16,3 +16,22 @@ var PatientFields = struct {
 
 // P is a short alias for PatientFields
 var P = PatientFields
+
+func init() {
+	shared.RegisterChangeDetector(detectPatientChanges)
+}
+
+// detectPatientChanges returns the fields of a Patient that differ from its snapshot
+func detectPatientChanges(current, original *Patient) []string {
+	var changed []string
+	if current.ID != original.ID {
+		changed = append(changed, "ID")
+	}
+	if current.Name != original.Name {
+		changed = append(changed, "Name")
+	}
+	if current.Age != original.Age {
+		changed = append(changed, "Age")
+	}
+	return changed
+}
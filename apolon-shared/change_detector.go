@@ -0,0 +1,53 @@
+package shared
+
+import (
+	"reflect"
+	"sync"
+)
+
+// ChangeDetector snapshots an entity and reports which fields changed since.
+// Implementations are generated by apolon-cli so tracking avoids reflection.
+type ChangeDetector struct {
+	// Snapshot returns a copy of the entity's current values
+	Snapshot func(entity any) any
+	// Changes returns the names of fields that differ from the snapshot
+	Changes func(entity any, snapshot any) []string
+}
+
+var changeDetectors = struct {
+	sync.RWMutex
+	byType map[reflect.Type]*ChangeDetector
+}{byType: make(map[reflect.Type]*ChangeDetector)}
+
+// RegisterChangeDetector registers a generated compare function for T.
+// Snapshots are shallow copies of the struct.
+func RegisterChangeDetector[T any](changes func(current, original *T) []string) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+
+	changeDetectors.Lock()
+	defer changeDetectors.Unlock()
+
+	changeDetectors.byType[t] = &ChangeDetector{
+		Snapshot: func(entity any) any {
+			snapshot := *entity.(*T)
+			return &snapshot
+		},
+		Changes: func(entity any, snapshot any) []string {
+			return changes(entity.(*T), snapshot.(*T))
+		},
+	}
+}
+
+// LookupChangeDetector returns the registered detector for t, or nil
+func LookupChangeDetector(t reflect.Type) *ChangeDetector {
+	changeDetectors.RLock()
+	defer changeDetectors.RUnlock()
+
+	return changeDetectors.byType[t]
+}
+
+// Equal reports whether two field values are deeply equal.
+// Generated compare functions use it for non-comparable types.
+func Equal(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
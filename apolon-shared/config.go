@@ -0,0 +1,91 @@
+package shared
+
+import "reflect"
+
+// EntityConfig holds mapping configured in code for an entity type.
+// Configured values take precedence over apolon struct tags.
+type EntityConfig struct {
+	Type       reflect.Type
+	Table      string                     // Table name, may be schema-qualified
+	Key        []string                   // Names of the key fields
+	Properties map[string]*PropertyConfig // Property overrides keyed by field name
+	Ignored    map[string]bool            // Fields excluded from the mapping
+	Indexes    []*IndexConfig
+	Checks     []CheckInfo // Table-level CHECK constraints
+	Data       []any       // Seed rows, values of Type
+}
+
+// PropertyConfig holds mapping configured in code for a single field.
+// Zero values leave the tag or convention in place.
+type PropertyConfig struct {
+	Column       string
+	SQLType      string
+	Required     bool
+	Unique       bool
+	MaxLength    int
+	DefaultValue *string
+	Comment      string
+}
+
+// NewEntityConfig creates an empty configuration for t
+func NewEntityConfig(t reflect.Type) *EntityConfig {
+	return &EntityConfig{
+		Type:       t,
+		Properties: make(map[string]*PropertyConfig),
+		Ignored:    make(map[string]bool),
+	}
+}
+
+// Property returns the configuration of a field, creating it if needed
+func (c *EntityConfig) Property(name string) *PropertyConfig {
+	prop, ok := c.Properties[name]
+	if !ok {
+		prop = &PropertyConfig{}
+		c.Properties[name] = prop
+	}
+	return prop
+}
+
+// applyTo overrides the column metadata of a field with its configured
+// property and key settings
+func (c *EntityConfig) applyTo(name string, col *ColumnInfo) {
+	if prop, ok := c.Properties[name]; ok {
+		prop.apply(col)
+	}
+
+	if len(c.Key) > 0 {
+		col.IsPrimaryKey = false
+		for _, key := range c.Key {
+			if key == name {
+				// PKs are implicitly NOT NULL
+				col.IsPrimaryKey = true
+				col.IsNotNull = true
+			}
+		}
+	}
+}
+
+// apply overrides column metadata with the configured values
+func (p *PropertyConfig) apply(col *ColumnInfo) {
+	if p.Column != "" {
+		col.Name = p.Column
+	}
+	if p.SQLType != "" {
+		col.SQLType = p.SQLType
+	}
+	if p.Required {
+		col.IsNotNull = true
+	}
+	if p.Unique {
+		col.IsUnique = true
+	}
+	if p.MaxLength > 0 {
+		col.Size = p.MaxLength
+	}
+	if p.DefaultValue != nil {
+		col.DefaultValue = p.DefaultValue
+	}
+	if p.Comment != "" {
+		col.Comment = p.Comment
+	}
+}
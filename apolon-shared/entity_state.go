@@ -1,5 +1,7 @@
 package shared
 
+import "fmt"
+
 // EntityState represents the state of an entity in the change tracker
 type EntityState int
 
@@ -32,3 +34,19 @@ func (s EntityState) String() string {
 		return "Unknown"
 	}
 }
+
+// MarshalText encodes the state by name, e.g. for JSON change sets
+func (s EntityState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a state name produced by MarshalText
+func (s *EntityState) UnmarshalText(text []byte) error {
+	for state := Detached; state <= Deleted; state++ {
+		if state.String() == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown entity state %q", text)
+}
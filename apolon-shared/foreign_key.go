@@ -0,0 +1,77 @@
+package shared
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ForeignKeyInfo contains metadata about a foreign key constraint
+type ForeignKeyInfo struct {
+	Name       string
+	Columns    []string
+	RefTable   string // Referenced table, may be schema-qualified
+	RefColumns []string
+	OnDelete   string // CASCADE, SET NULL, RESTRICT or NO ACTION; empty for the default
+}
+
+// Navigation describes a field referencing other entities
+type Navigation struct {
+	Name     string       // Go field name
+	Index    []int        // Field index path for reflect.Value.FieldByIndex
+	Target   reflect.Type // Referenced entity type
+	OnDelete string       // ON DELETE action of the foreign key behind the navigation
+}
+
+// parseForeignKeyTag parses the value of an fk:table.column option. The
+// table may be schema-qualified, e.g. fk:clinical.patients.id.
+func parseForeignKeyTag(ref string) (table, column string, err error) {
+	table, column = SplitTableName(ref)
+	if table == "" || column == "" {
+		return "", "", fmt.Errorf("invalid foreign key %q, expected fk:table.column", ref)
+	}
+	return table, column, nil
+}
+
+// parseOnDelete maps an ondelete: option to its SQL referential action
+func parseOnDelete(action string) (string, error) {
+	switch strings.ToLower(action) {
+	case "cascade":
+		return "CASCADE", nil
+	case "setnull":
+		return "SET NULL", nil
+	case "restrict":
+		return "RESTRICT", nil
+	case "noaction":
+		return "NO ACTION", nil
+	}
+	return "", fmt.Errorf("invalid ondelete action %q, expected cascade, setnull, restrict or noaction", action)
+}
+
+// parseNavigationTag parses the options of a navigation field, which may
+// only set the ON DELETE action of its foreign key
+func parseNavigationTag(tag string) (string, error) {
+	onDelete := ""
+	for _, opt := range strings.Split(tag, ",")[1:] {
+		opt = strings.TrimSpace(opt)
+		switch {
+		case opt == "":
+		case strings.HasPrefix(opt, "ondelete:"):
+			action, err := parseOnDelete(strings.TrimPrefix(opt, "ondelete:"))
+			if err != nil {
+				return "", err
+			}
+			onDelete = action
+		default:
+			return "", fmt.Errorf("unknown navigation tag option %q", opt)
+		}
+	}
+	return onDelete, nil
+}
+
+// ForeignKeyName derives a constraint name from the table and its columns,
+// e.g. fk_appointments_patient_id
+func ForeignKeyName(table string, columns ...string) string {
+	_, table = SplitTableName(table)
+	return "fk_" + table + "_" + strings.Join(columns, "_")
+}
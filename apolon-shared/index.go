@@ -0,0 +1,149 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IndexInfo contains metadata about a table index
+type IndexInfo struct {
+	Name         string
+	Columns      []string // Column names or SQL expressions, in index order
+	Unique       bool
+	Method       string // Access method, e.g. btree or gin; empty for the default
+	Where        string // Predicate of a partial index
+	Concurrently bool   // Build without locking writes; not allowed in a transaction
+}
+
+// IndexConfig holds an index configured in code, the only way to set
+// Method, Where and Concurrently
+type IndexConfig struct {
+	Name         string
+	Fields       []string // Go field names, mapped to columns
+	Expressions  []string // SQL expressions, used when Fields is empty
+	Unique       bool
+	Method       string
+	Where        string
+	Concurrently bool
+}
+
+// indexTag is an index, index:name, uniqueindex or uniqueindex:name tag
+// option. Tags declare plain btree indexes only; the access method, partial
+// predicate and concurrent builds are fluent-only, see IndexConfig.
+type indexTag struct {
+	name   string
+	unique bool
+}
+
+// parseIndexTag parses an index tag option, reporting false for other options
+func parseIndexTag(opt string) (indexTag, bool) {
+	for _, prefix := range []string{"uniqueindex", "index"} {
+		if opt == prefix || strings.HasPrefix(opt, prefix+":") {
+			return indexTag{
+				name:   strings.TrimPrefix(strings.TrimPrefix(opt, prefix), ":"),
+				unique: prefix == "uniqueindex",
+			}, true
+		}
+	}
+	return indexTag{}, false
+}
+
+// addTagIndex adds a column to the index declared by a tag option. Tags
+// sharing a name form a composite index in field order; unnamed tags get
+// idx_<table>_<column> or uidx_<table>_<column>. The index has no Method,
+// Where or Concurrently, which only HasIndex in OnModelCreating sets.
+func (m *Model) addTagIndex(tag indexTag, column string) error {
+	name := tag.name
+	if name == "" {
+		name = defaultIndexName(m.Table, tag.unique, column)
+	}
+
+	for i := range m.Indexes {
+		idx := &m.Indexes[i]
+		if idx.Name != name {
+			continue
+		}
+		if idx.Unique != tag.unique {
+			return fmt.Errorf("index %s is declared both unique and non-unique", name)
+		}
+		idx.Columns = append(idx.Columns, column)
+		return nil
+	}
+
+	m.Indexes = append(m.Indexes, IndexInfo{Name: name, Columns: []string{column}, Unique: tag.unique})
+	return nil
+}
+
+// addConfiguredIndex resolves the fields of a configured index to columns
+// and adds it to the model
+func (m *Model) addConfiguredIndex(cfg *IndexConfig) error {
+	idx := IndexInfo{
+		Name:         cfg.Name,
+		Unique:       cfg.Unique,
+		Method:       cfg.Method,
+		Where:        cfg.Where,
+		Concurrently: cfg.Concurrently,
+	}
+
+	for _, name := range cfg.Fields {
+		field := m.byName[name]
+		if field == nil {
+			return fmt.Errorf("index field %s is not mapped to a column", name)
+		}
+		idx.Columns = append(idx.Columns, field.Column.Name)
+	}
+	if len(cfg.Fields) == 0 {
+		idx.Columns = append(idx.Columns, cfg.Expressions...)
+	}
+	if len(idx.Columns) == 0 {
+		return fmt.Errorf("index %s has no columns", cfg.Name)
+	}
+
+	if idx.Name == "" {
+		idx.Name = defaultIndexName(m.Table, idx.Unique, idx.Columns...)
+	}
+	for _, existing := range m.Indexes {
+		if existing.Name == idx.Name {
+			return fmt.Errorf("index %s is declared more than once", idx.Name)
+		}
+	}
+
+	m.Indexes = append(m.Indexes, idx)
+	return nil
+}
+
+// defaultIndexName derives an index name from the table and its columns,
+// reducing expressions to identifier characters, e.g. lower(email) -> lower_email
+func defaultIndexName(table string, unique bool, columns ...string) string {
+	_, table = SplitTableName(table)
+
+	prefix := "idx"
+	if unique {
+		prefix = "uidx"
+	}
+
+	parts := []string{prefix, table}
+	for _, column := range columns {
+		parts = append(parts, identifierPart(column))
+	}
+	return strings.Join(parts, "_")
+}
+
+// identifierPart lowercases s and replaces runs of characters that are not
+// letters, digits or underscores with a single underscore
+func identifierPart(s string) string {
+	var sb strings.Builder
+	pending := false
+	for _, r := range strings.ToLower(s) {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if pending && sb.Len() > 0 {
+				sb.WriteByte('_')
+			}
+			pending = false
+			sb.WriteRune(r)
+			continue
+		}
+		pending = true
+	}
+	return sb.String()
+}
@@ -0,0 +1,77 @@
+package shared
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type indexedSlot struct {
+	ID        int    `apolon:"id,pk"`
+	DoctorID  int    `apolon:"doctor_id,uniqueindex:uidx_doctor_slot"`
+	StartsAt  string `apolon:"starts_at,uniqueindex:uidx_doctor_slot,index:idx_slot_range"`
+	EndsAt    string `apolon:"ends_at,index:idx_slot_range"`
+	PatientID int    `apolon:"patient_id,index"`
+	Code      string `apolon:"code,uniqueindex"`
+}
+
+func TestParseIndexTag(t *testing.T) {
+	tests := []struct {
+		opt    string
+		want   indexTag
+		parsed bool
+	}{
+		{"index", indexTag{}, true},
+		{"index:idx_name", indexTag{name: "idx_name"}, true},
+		{"uniqueindex", indexTag{unique: true}, true},
+		{"uniqueindex:uidx_name", indexTag{name: "uidx_name", unique: true}, true},
+		{"indexed", indexTag{}, false},
+		{"unique", indexTag{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseIndexTag(tt.opt)
+		if got != tt.want || ok != tt.parsed {
+			t.Errorf("parseIndexTag(%q) = %+v, %v, want %+v, %v", tt.opt, got, ok, tt.want, tt.parsed)
+		}
+	}
+}
+
+func TestTagIndexes(t *testing.T) {
+	m, err := BuildModel(reflect.TypeOf(indexedSlot{}), DefaultNamingStrategy{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, idx := range m.Indexes {
+		if idx.Method != "" || idx.Where != "" || idx.Concurrently {
+			t.Errorf("tag index %s = %+v, want a plain index", idx.Name, idx)
+		}
+		desc := idx.Name + "(" + strings.Join(idx.Columns, ",") + ")"
+		if idx.Unique {
+			desc += " unique"
+		}
+		got = append(got, desc)
+	}
+	// Indexes are ordered by their first column, columns by field order
+	want := []string{
+		"uidx_doctor_slot(doctor_id,starts_at) unique",
+		"idx_slot_range(starts_at,ends_at)",
+		"idx_indexedslots_patient_id(patient_id)",
+		"uidx_indexedslots_code(code) unique",
+	}
+	if strings.Join(got, "; ") != strings.Join(want, "; ") {
+		t.Errorf("Indexes = %v, want %v", got, want)
+	}
+}
+
+func TestTagIndexUniquenessConflict(t *testing.T) {
+	type slot struct {
+		A int `apolon:"a,index:idx_ab"`
+		B int `apolon:"b,uniqueindex:idx_ab"`
+	}
+	_, err := BuildModel(reflect.TypeOf(slot{}), DefaultNamingStrategy{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "field B: index idx_ab is declared both unique and non-unique") {
+		t.Errorf("BuildModel() error = %v, want a uniqueness conflict", err)
+	}
+}
@@ -1,52 +1,422 @@
 package shared
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
 	"reflect"
 	"strings"
+	"time"
 )
 
 // ModelInfo contains metadata about a database model
 type ModelInfo struct {
 	Table  string
 	Fields []string
+
+	// SoftDeleteField and SoftDeleteColumn identify the field tagged with
+	// the softdelete option, empty if the model is hard-deleted
+	SoftDeleteField  string
+	SoftDeleteColumn string
 }
 
-// ParseModel extracts model metadata using reflection
-func ParseModel(v interface{}) *ModelInfo {
-	t := reflect.TypeOf(v)
+// Field describes a struct field mapped to a column
+type Field struct {
+	Name   string       // Go field name
+	Index  []int        // Index sequence for reflect.Value.FieldByIndex
+	Type   reflect.Type // Go type of the field
+	Column ColumnInfo   // Column the field maps to
+}
+
+// Model is the mapping metadata of an entity type. Models are immutable
+// once built and are shared between goroutines through a ModelRegistry.
+type Model struct {
+	Type        reflect.Type
+	Table       string   // Table name, schema-qualified if Schema is set
+	Schema      string   // Postgres schema, empty for the search path default
+	Fields      []*Field // Mapped fields in declaration order
+	Key         []*Field // Key fields in declaration order, empty if the model has none
+	SoftDelete  *Field   // Field tagged softdelete, nil if rows are hard-deleted
+	Indexes     []IndexInfo
+	ForeignKeys []ForeignKeyInfo // Foreign keys declared with fk tags
+	Navigations []*Navigation    // Fields referencing other entities
+	Checks      []CheckInfo      // Table-level checks configured in code
+	Data        []any            // Seed rows configured in code
+
+	byName   map[string]*Field
+	byColumn map[string]*Field
+}
+
+// FieldByName returns the mapped field with the given Go name, or nil
+func (m *Model) FieldByName(name string) *Field {
+	return m.byName[name]
+}
+
+// FieldByColumn returns the field mapped to the given column, or nil
+func (m *Model) FieldByColumn(column string) *Field {
+	return m.byColumn[column]
+}
+
+// IsKey reports whether f is part of the model's key
+func (m *Model) IsKey(f *Field) bool {
+	for _, key := range m.Key {
+		if key == f {
+			return true
+		}
+	}
+	return false
+}
+
+// HasCompositeKey reports whether the key spans more than one field
+func (m *Model) HasCompositeKey() bool {
+	return len(m.Key) > 1
+}
+
+// Columns returns the column names of all mapped fields in order
+func (m *Model) Columns() []string {
+	columns := make([]string, len(m.Fields))
+	for i, f := range m.Fields {
+		columns[i] = f.Column.Name
+	}
+	return columns
+}
+
+// ModelInfo returns the query metadata of the model
+func (m *Model) ModelInfo() *ModelInfo {
+	info := &ModelInfo{
+		Table:  m.Table,
+		Fields: m.Columns(),
+	}
+	if m.SoftDelete != nil {
+		info.SoftDeleteField = m.SoftDelete.Name
+		info.SoftDeleteColumn = m.SoftDelete.Column.Name
+	}
+	return info
+}
+
+// SchemaInfo returns the DDL metadata of the model
+func (m *Model) SchemaInfo() *SchemaInfo {
+	columns := make([]ColumnInfo, len(m.Fields))
+	for i, f := range m.Fields {
+		columns[i] = f.Column
+	}
+
+	var primaryKey []string
+	for _, f := range m.Key {
+		if f.Column.IsPrimaryKey {
+			primaryKey = append(primaryKey, f.Column.Name)
+		}
+	}
+	return &SchemaInfo{
+		Table:       m.Table,
+		Schema:      m.Schema,
+		Columns:     columns,
+		PrimaryKey:  primaryKey,
+		Indexes:     m.Indexes,
+		ForeignKeys: m.ForeignKeys,
+		Checks:      m.Checks,
+	}
+}
+
+// BuildModel parses the apolon tags of a struct type, naming untagged
+// tables and columns with the given strategy and applying the optional
+// configuration on top. The returned model is always usable; invalid tags
+// and configuration are reported in the error and otherwise ignored.
+func BuildModel(t reflect.Type, naming NamingStrategy, cfg *EntityConfig) (*Model, error) {
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
 
-	fields := []string{}
+	m := &Model{
+		Type:     t,
+		byName:   make(map[string]*Field),
+		byColumn: make(map[string]*Field),
+	}
+	if t.Kind() != reflect.Struct {
+		return m, fmt.Errorf("invalid model %s: not a struct", t)
+	}
+
+	var errs []error
+	if err := validateMarker(t); err != nil {
+		errs = append(errs, err)
+	}
+	m.Table = TableNameWith(t, naming)
+	if cfg != nil && cfg.Table != "" {
+		m.Table = cfg.Table
+	}
+	m.Schema, _ = SplitTableName(m.Table)
+
+	w := &fieldWalker{model: m, naming: naming, cfg: cfg}
+	w.walk(t, nil, "", "")
+	errs = append(errs, w.errs...)
+	if cfg != nil {
+		errs = append(errs, validateConfig(m, cfg)...)
+		m.Checks = cfg.Checks
+		m.Data = cfg.Data
+		for _, idx := range cfg.Indexes {
+			if err := m.addConfiguredIndex(idx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	// Configured keys keep the order given to HasKey
+	if cfg != nil && len(cfg.Key) > 0 {
+		m.Key = nil
+		for _, name := range cfg.Key {
+			if field := m.byName[name]; field != nil {
+				m.Key = append(m.Key, field)
+			}
+		}
+	}
+
+	// Determine SQL types not overridden; composite keys are not generated
+	for _, field := range m.Fields {
+		if field.Column.SQLType == "" {
+			serial := field.Column.IsPrimaryKey && !m.HasCompositeKey()
+			field.Column.SQLType = goTypeToSQLType(field.Type, &field.Column, serial)
+		}
+	}
+
+	// Fall back to a field named ID when no field is tagged pk
+	if len(m.Key) == 0 {
+		if id := m.byName["ID"]; id != nil {
+			m.Key = []*Field{id}
+		}
+	}
+
+	if len(errs) > 0 {
+		return m, fmt.Errorf("invalid model %s: %w", t.Name(), errors.Join(errs...))
+	}
+	return m, nil
+}
+
+// fieldWalker collects the mapped fields of a model, flattening embedded
+// structs and owned value types into columns
+type fieldWalker struct {
+	model  *Model
+	naming NamingStrategy
+	cfg    *EntityConfig
+	errs   []error
+}
+
+// walk adds the fields of struct type t, reached through index, to the
+// model. Field names are prefixed with namePrefix (e.g. "Address.") and
+// column names with columnPrefix (e.g. "address_").
+func (w *fieldWalker) walk(t reflect.Type, index []int, namePrefix, columnPrefix string) {
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+		tag := f.Tag.Get("apolon")
+
+		// Embedded structs are flattened without a prefix
+		if f.Anonymous && f.IsExported() && tag != "-" && isOwnedType(f.Type) {
+			w.walk(f.Type, fieldIndex, namePrefix, columnPrefix)
+			continue
+		}
 
 		// Skip unexported fields
 		if !f.IsExported() {
 			continue
 		}
 
-		tag := f.Tag.Get("apolon")
-
 		// Skip fields with apolon:"-"
-		if tag == "-" {
+		name := namePrefix + f.Name
+		if tag == "-" || (w.cfg != nil && w.cfg.Ignored[name]) {
 			continue
 		}
 
-		if tag == "" {
-			tag = strings.ToLower(f.Name)
-		} else {
-			// Handle comma-separated options like "id,pk" - take only the column name
-			if idx := strings.Index(tag, ","); idx != -1 {
-				tag = tag[:idx]
+		// Navigations to other entities are recorded as relationships
+		if IsNavigation(f) {
+			w.addNavigation(f, fieldIndex, name, tag)
+			continue
+		}
+
+		// Owned value types map to columns prefixed with the field's column
+		if isOwnedType(f.Type) {
+			prefix := strings.Split(tag, ",")[0]
+			if prefix == "" {
+				prefix = w.naming.ColumnName(f.Name)
 			}
+			w.walk(f.Type, fieldIndex, name+".", columnPrefix+prefix+"_")
+			continue
+		}
+
+		w.add(f, fieldIndex, name, columnPrefix, tag)
+	}
+}
+
+// add maps a single struct field to a column
+func (w *fieldWalker) add(f reflect.StructField, index []int, name, columnPrefix, tag string) {
+	m := w.model
+	field := &Field{
+		Name:  name,
+		Index: index,
+		Type:  f.Type,
+	}
+	col, opts, err := parseColumnInfo(f, tag, w.naming)
+	if err != nil {
+		w.errs = append(w.errs, fmt.Errorf("field %s: %w", name, err))
+	}
+	col.Name = columnPrefix + col.Name
+	if w.cfg != nil {
+		w.cfg.applyTo(name, &col)
+	}
+	field.Column = col
+
+	if existing, ok := m.byColumn[col.Name]; ok {
+		w.errs = append(w.errs, fmt.Errorf("fields %s and %s both map to column %q", existing.Name, name, col.Name))
+		return
+	}
+
+	if col.IsPrimaryKey {
+		m.Key = append(m.Key, field)
+	}
+
+	for _, idx := range opts.indexes {
+		if err := m.addTagIndex(idx, col.Name); err != nil {
+			w.errs = append(w.errs, fmt.Errorf("field %s: %w", name, err))
+		}
+	}
+
+	if opts.references != "" {
+		table, column, _ := parseForeignKeyTag(opts.references)
+		m.ForeignKeys = append(m.ForeignKeys, ForeignKeyInfo{
+			Name:       ForeignKeyName(m.Table, col.Name),
+			Columns:    []string{col.Name},
+			RefTable:   table,
+			RefColumns: []string{column},
+			OnDelete:   opts.onDelete,
+		})
+	} else if opts.onDelete != "" {
+		w.errs = append(w.errs, fmt.Errorf("field %s: ondelete requires an fk option", name))
+	}
+
+	if opts.softDelete {
+		if !isTimeType(f.Type) {
+			w.errs = append(w.errs, fmt.Errorf("field %s: softdelete requires time.Time or *time.Time, got %s", name, f.Type))
+		} else if m.SoftDelete != nil {
+			w.errs = append(w.errs, fmt.Errorf("fields %s and %s are both tagged softdelete", m.SoftDelete.Name, name))
+		} else {
+			m.SoftDelete = field
+		}
+	}
+
+	m.Fields = append(m.Fields, field)
+	m.byName[name] = field
+	m.byColumn[col.Name] = field
+}
+
+// addNavigation records a field referencing other entities
+func (w *fieldWalker) addNavigation(f reflect.StructField, index []int, name, tag string) {
+	onDelete, err := parseNavigationTag(tag)
+	if err != nil {
+		w.errs = append(w.errs, fmt.Errorf("field %s: %w", name, err))
+	}
+	w.model.Navigations = append(w.model.Navigations, &Navigation{
+		Name:     name,
+		Index:    index,
+		Target:   NavigationTarget(f.Type),
+		OnDelete: onDelete,
+	})
+}
+
+// isOwnedType reports whether t is a struct value mapped to several
+// columns, rather than a single column such as time.Time or sql.NullString
+func isOwnedType(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return false
+	}
+	return !reflect.PointerTo(t).Implements(scannerType) && !t.Implements(valuerType)
+}
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// validateConfig checks that configured fields exist and are mapped
+func validateConfig(m *Model, cfg *EntityConfig) []error {
+	var errs []error
+
+	check := func(kind, name string) {
+		if m.byName[name] != nil {
+			return
+		}
+		if _, ok := lookupField(m.Type, name); ok {
+			errs = append(errs, fmt.Errorf("configured %s %s is not mapped to a column", kind, name))
+		} else {
+			errs = append(errs, fmt.Errorf("configured %s %s does not exist", kind, name))
+		}
+	}
+
+	for _, name := range cfg.Key {
+		check("key", name)
+	}
+	for name := range cfg.Properties {
+		check("property", name)
+	}
+	for name := range cfg.Ignored {
+		if _, ok := lookupField(m.Type, name); !ok {
+			errs = append(errs, fmt.Errorf("configured ignored field %s does not exist", name))
+		}
+	}
+	return errs
+}
+
+// lookupField finds an exported field by a dotted path such as "Address.City"
+func lookupField(t reflect.Type, path string) (reflect.StructField, bool) {
+	var f reflect.StructField
+	for _, name := range strings.Split(path, ".") {
+		if t.Kind() != reflect.Struct {
+			return f, false
 		}
-		fields = append(fields, tag)
+		var ok bool
+		f, ok = t.FieldByName(name)
+		if !ok || !f.IsExported() {
+			return f, false
+		}
+		t = f.Type
+	}
+	return f, true
+}
+
+// validateMarker checks the options of a `_` marker field
+func validateMarker(t reflect.Type) error {
+	marker, ok := t.FieldByName("_")
+	if !ok {
+		return nil
 	}
 
-	return &ModelInfo{
-		Table:  strings.ToLower(t.Name()) + "s",
-		Fields: fields,
+	for _, opt := range strings.Split(marker.Tag.Get("apolon"), ",") {
+		opt = strings.TrimSpace(opt)
+		switch {
+		case opt == "", strings.HasPrefix(opt, "table:"), strings.HasPrefix(opt, "schema:"):
+		default:
+			return fmt.Errorf("marker field: unknown tag option %q", opt)
+		}
+	}
+	return nil
+}
+
+// isTimeType reports whether t is time.Time or *time.Time
+func isTimeType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
+	return t == reflect.TypeOf(time.Time{})
+}
+
+// ParseModel extracts model metadata using reflection
+func ParseModel(v interface{}) *ModelInfo {
+	return ParseModelWith(v, DefaultNamingStrategy{})
+}
+
+// ParseModelWith extracts model metadata, naming untagged tables and
+// columns with the given strategy. Invalid tags are ignored; use a
+// ModelRegistry to report them.
+func ParseModelWith(v interface{}, naming NamingStrategy) *ModelInfo {
+	m, _ := BuildModel(reflect.TypeOf(v), naming, nil)
+	return m.ModelInfo()
 }
@@ -0,0 +1,162 @@
+package shared
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type modelAddress struct {
+	Street string `apolon:"street"`
+	City   string `apolon:"city,notnull"`
+}
+
+// Audited is exported, as only exported embedded structs are flattened
+type Audited struct {
+	CreatedAt time.Time  `apolon:"created_at"`
+	DeletedAt *time.Time `apolon:"deleted_at,softdelete"`
+}
+
+type modelPatient struct {
+	Audited
+	ID       int          `apolon:"id,pk"`
+	Email    string       `apolon:"email,unique,size:100"`
+	Status   string       `apolon:"status,default:'active',index"`
+	Notes    string       `apolon:"notes,type:jsonb" check:"notes <> ''" comment:"Free text"`
+	ClinicID int64        `apolon:"clinic_id,fk:clinics.id,ondelete:cascade"`
+	Home     modelAddress `apolon:"home"`
+	Secret   string       `apolon:"-"`
+	Nickname string
+	internal string
+}
+
+type modelLine struct {
+	OrderID int `apolon:"order_id,pk"`
+	LineNo  int `apolon:"line_no,pk"`
+}
+
+func TestBuildModelTags(t *testing.T) {
+	m, err := BuildModel(reflect.TypeOf(modelPatient{}), DefaultNamingStrategy{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Table != "modelpatients" {
+		t.Errorf("Table = %q, want modelpatients", m.Table)
+	}
+	want := "created_at,deleted_at,id,email,status,notes,clinic_id,home_street,home_city,nickname"
+	if got := strings.Join(m.Columns(), ","); got != want {
+		t.Errorf("Columns() = %s, want %s", got, want)
+	}
+
+	columns := map[string]struct {
+		sqlType string
+		check   func(ColumnInfo) bool
+	}{
+		"id":         {"SERIAL", func(c ColumnInfo) bool { return c.IsPrimaryKey && c.IsNotNull }},
+		"email":      {"VARCHAR(100)", func(c ColumnInfo) bool { return c.IsUnique }},
+		"status":     {"TEXT", func(c ColumnInfo) bool { return c.DefaultValue != nil && *c.DefaultValue == "'active'" }},
+		"notes":      {"jsonb", func(c ColumnInfo) bool { return c.Check == "notes <> ''" && c.Comment == "Free text" }},
+		"clinic_id":  {"BIGINT", func(c ColumnInfo) bool { return !c.IsNotNull }},
+		"home_city":  {"TEXT", func(c ColumnInfo) bool { return c.IsNotNull }},
+		"created_at": {"TIMESTAMP WITH TIME ZONE", func(c ColumnInfo) bool { return true }},
+	}
+	for column, want := range columns {
+		field := m.FieldByColumn(column)
+		if field == nil {
+			t.Errorf("column %s is not mapped", column)
+			continue
+		}
+		if field.Column.SQLType != want.sqlType || !want.check(field.Column) {
+			t.Errorf("column %s = %+v, want type %s", column, field.Column, want.sqlType)
+		}
+	}
+
+	if field := m.FieldByName("Home.City"); field == nil || field.Column.Name != "home_city" {
+		t.Errorf("FieldByName(Home.City) = %+v, want the home_city column", field)
+	}
+	if len(m.Key) != 1 || m.Key[0].Name != "ID" {
+		t.Errorf("Key = %v, want ID", m.Key)
+	}
+	if m.SoftDelete == nil || m.SoftDelete.Name != "DeletedAt" {
+		t.Errorf("SoftDelete = %v, want DeletedAt", m.SoftDelete)
+	}
+	if len(m.Indexes) != 1 || strings.Join(m.Indexes[0].Columns, ",") != "status" || m.Indexes[0].Unique {
+		t.Errorf("Indexes = %+v, want a non-unique index on status", m.Indexes)
+	}
+	if len(m.ForeignKeys) != 1 {
+		t.Fatalf("ForeignKeys = %+v, want one", m.ForeignKeys)
+	}
+	fk := m.ForeignKeys[0]
+	if fk.RefTable != "clinics" || strings.Join(fk.RefColumns, ",") != "id" || fk.OnDelete == "" {
+		t.Errorf("ForeignKeys[0] = %+v, want clinic_id referencing clinics.id on delete cascade", fk)
+	}
+}
+
+func TestBuildModelCompositeKey(t *testing.T) {
+	m, err := BuildModel(reflect.TypeOf(modelLine{}), DefaultNamingStrategy{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.HasCompositeKey() || m.Key[0].Name != "OrderID" || m.Key[1].Name != "LineNo" {
+		t.Errorf("Key = %v, want OrderID, LineNo", m.Key)
+	}
+	// Composite keys are not generated
+	for _, field := range m.Key {
+		if field.Column.SQLType != "INTEGER" {
+			t.Errorf("%s type = %s, want INTEGER", field.Name, field.Column.SQLType)
+		}
+	}
+
+	// Configured keys keep the configured order
+	cfg := NewEntityConfig(reflect.TypeOf(modelLine{}))
+	cfg.Key = []string{"LineNo", "OrderID"}
+	m, err = BuildModel(reflect.TypeOf(modelLine{}), DefaultNamingStrategy{}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Key[0].Name != "LineNo" || m.Key[1].Name != "OrderID" {
+		t.Errorf("configured Key = %v, want LineNo, OrderID", m.Key)
+	}
+}
+
+func TestBuildModelReportsInvalidTags(t *testing.T) {
+	tests := []struct {
+		name  string
+		model any
+		want  string
+	}{
+		{"unknown option", struct {
+			ID int `apolon:"id,pk,primary"`
+		}{}, `field ID: unknown tag option "primary"`},
+		{"invalid size", struct {
+			Name string `apolon:"name,size:0"`
+		}{}, `field Name: invalid size "0"`},
+		{"empty type", struct {
+			Name string `apolon:"name,type:"`
+		}{}, "field Name: empty type option"},
+		{"ondelete without fk", struct {
+			OwnerID int `apolon:"owner_id,ondelete:cascade"`
+		}{}, "field OwnerID: ondelete requires an fk option"},
+		{"softdelete type", struct {
+			Deleted bool `apolon:"deleted,softdelete"`
+		}{}, "field Deleted: softdelete requires time.Time or *time.Time"},
+		{"duplicate column", struct {
+			A string `apolon:"name"`
+			B string `apolon:"name"`
+		}{}, `fields A and B both map to column "name"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := BuildModel(reflect.TypeOf(tt.model), DefaultNamingStrategy{}, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("BuildModel() error = %v, want %q", err, tt.want)
+			}
+			if m == nil {
+				t.Error("BuildModel() returned no model")
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// NamingStrategy maps Go type and field names to table and column names
+// for models without explicit names
+type NamingStrategy interface {
+	// TableName returns the table name for a struct type name
+	TableName(typeName string) string
+	// ColumnName returns the column name for a struct field name
+	ColumnName(fieldName string) string
+}
+
+// DefaultNamingStrategy lowercases names and appends "s" to table names,
+// e.g. FirstName -> firstname, Category -> categorys
+type DefaultNamingStrategy struct{}
+
+// TableName returns the lowercased type name with an "s" suffix
+func (DefaultNamingStrategy) TableName(typeName string) string {
+	return strings.ToLower(typeName) + "s"
+}
+
+// ColumnName returns the lowercased field name
+func (DefaultNamingStrategy) ColumnName(fieldName string) string {
+	return strings.ToLower(fieldName)
+}
+
+// SnakeCaseNamingStrategy converts names to snake_case and pluralizes table
+// names using English rules, e.g. FirstName -> first_name, Category -> categories
+type SnakeCaseNamingStrategy struct {
+	TablePrefix    string // Prepended to table names, e.g. "app_"
+	SingularTables bool   // Disables pluralization of table names
+}
+
+// TableName returns the prefixed, pluralized snake_case type name
+func (s SnakeCaseNamingStrategy) TableName(typeName string) string {
+	name := ToSnakeCase(typeName)
+	if !s.SingularTables {
+		name = Pluralize(name)
+	}
+	return s.TablePrefix + name
+}
+
+// ColumnName returns the snake_case field name
+func (s SnakeCaseNamingStrategy) ColumnName(fieldName string) string {
+	return ToSnakeCase(fieldName)
+}
+
+// IdentityNamingStrategy uses Go names unchanged, e.g. FirstName -> FirstName.
+// Postgres folds unquoted identifiers to lower case, so this suits
+// case-insensitive lookups only.
+type IdentityNamingStrategy struct {
+	TablePrefix string // Prepended to table names
+}
+
+// TableName returns the prefixed type name
+func (s IdentityNamingStrategy) TableName(typeName string) string {
+	return s.TablePrefix + typeName
+}
+
+// ColumnName returns the field name unchanged
+func (IdentityNamingStrategy) ColumnName(fieldName string) string {
+	return fieldName
+}
+
+// NamingStrategyByName returns the strategy for "default", "snake" or "identity"
+func NamingStrategyByName(name, tablePrefix string, singularTables bool) (NamingStrategy, error) {
+	switch name {
+	case "", "default":
+		return DefaultNamingStrategy{}, nil
+	case "snake", "snake_case":
+		return SnakeCaseNamingStrategy{TablePrefix: tablePrefix, SingularTables: singularTables}, nil
+	case "identity":
+		return IdentityNamingStrategy{TablePrefix: tablePrefix}, nil
+	default:
+		return nil, fmt.Errorf("unknown naming strategy %q", name)
+	}
+}
+
+// ToSnakeCase converts a Go identifier to snake_case, keeping acronyms
+// together: PatientID -> patient_id, HTTPServer -> http_server. A single
+// capital before a word is part of it: OAuth2Token -> oauth2_token.
+func ToSnakeCase(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			// A trailing plural "s" belongs to the acronym: UserIDs -> user_ids
+			pluralSuffix := i+2 == len(runes) && runes[i+1] == 's'
+			// The capital before ends an acronym of at least two letters
+			acronymEnd := i >= 2 && unicode.IsUpper(runes[i-2])
+
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				(unicode.IsUpper(prev) && nextLower && acronymEnd && !pluralSuffix) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+
+	return sb.String()
+}
+
+var irregularPlurals = map[string]string{
+	"person":    "people",
+	"man":       "men",
+	"woman":     "women",
+	"child":     "children",
+	"tooth":     "teeth",
+	"foot":      "feet",
+	"mouse":     "mice",
+	"goose":     "geese",
+	"ox":        "oxen",
+	"datum":     "data",
+	"criterion": "criteria",
+	"medium":    "media",
+}
+
+var uncountableNouns = map[string]bool{
+	"equipment":   true,
+	"information": true,
+	"metadata":    true,
+	"data":        true,
+	"feedback":    true,
+	"staff":       true,
+	"news":        true,
+	"series":      true,
+	"species":     true,
+	"sheep":       true,
+	"fish":        true,
+	"deer":        true,
+	"money":       true,
+}
+
+// Nouns ending in "f" that take "ves"; others (roof, chief, belief) take "s"
+var fToVes = map[string]bool{
+	"leaf": true, "half": true, "wolf": true, "shelf": true,
+	"loaf": true, "calf": true, "self": true, "thief": true,
+}
+
+// Nouns ending in "fe" that take "ves"; others (cafe, giraffe, safe) take "s"
+var feToVes = map[string]bool{
+	"knife": true, "wife": true, "life": true, "midwife": true, "housewife": true,
+}
+
+// Nouns ending in a single "z" that double it; others (waltz, topaz) take "es"
+var zToZzes = map[string]bool{
+	"quiz": true, "fez": true, "whiz": true,
+}
+
+// Nouns ending in a consonant and "o" that take "es"; others (photo, video) take "s"
+var oToOes = map[string]bool{
+	"hero": true, "potato": true, "tomato": true, "echo": true, "veto": true,
+}
+
+// Pluralize returns the English plural of a lower-case word. For snake_case
+// names only the last word is pluralized: medical_record -> medical_records.
+func Pluralize(name string) string {
+	prefix, word := "", name
+	if idx := strings.LastIndex(name, "_"); idx != -1 {
+		prefix, word = name[:idx+1], name[idx+1:]
+	}
+
+	return prefix + pluralizeWord(word)
+}
+
+// pluralizeWord applies irregular forms and suffix rules to a single word
+func pluralizeWord(word string) string {
+	if word == "" || uncountableNouns[word] {
+		return word
+	}
+	if plural, ok := irregularPlurals[word]; ok {
+		return plural
+	}
+
+	switch {
+	case zToZzes[word]:
+		return word + "zes"
+	case strings.HasSuffix(word, "is"):
+		return strings.TrimSuffix(word, "is") + "es"
+	case strings.HasSuffix(word, "s"), strings.HasSuffix(word, "x"), strings.HasSuffix(word, "z"),
+		strings.HasSuffix(word, "ch"), strings.HasSuffix(word, "sh"):
+		return word + "es"
+	case strings.HasSuffix(word, "y") && len(word) > 1 && !isVowel(word[len(word)-2]):
+		return strings.TrimSuffix(word, "y") + "ies"
+	case strings.HasSuffix(word, "fe") && feToVes[word]:
+		return strings.TrimSuffix(word, "fe") + "ves"
+	case strings.HasSuffix(word, "f") && fToVes[word]:
+		return strings.TrimSuffix(word, "f") + "ves"
+	case strings.HasSuffix(word, "o") && oToOes[word]:
+		return word + "es"
+	default:
+		return word + "s"
+	}
+}
+
+// isVowel reports whether b is an ASCII vowel
+func isVowel(b byte) bool {
+	return strings.IndexByte("aeiou", b) != -1
+}
@@ -0,0 +1,107 @@
+package shared
+
+import "testing"
+
+func TestToSnakeCase(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"ID", "id"},
+		{"FirstName", "first_name"},
+		{"PatientID", "patient_id"},
+		{"HTTPServer", "http_server"},
+		{"UserIDs", "user_ids"},
+		{"OAuth2Token", "oauth2_token"},
+		{"MyOAuthToken", "my_oauth_token"},
+		{"ETag", "etag"},
+		{"IOStream", "io_stream"},
+		{"Address2Line", "address2_line"},
+		{"already_snake", "already_snake"},
+	}
+	for _, tt := range tests {
+		if got := ToSnakeCase(tt.name); got != tt.want {
+			t.Errorf("ToSnakeCase(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPluralize(t *testing.T) {
+	tests := []struct {
+		word, want string
+	}{
+		{"patient", "patients"},
+		{"category", "categories"},
+		{"day", "days"},
+		{"box", "boxes"},
+		{"match", "matches"},
+		{"status", "statuses"},
+		{"analysis", "analyses"},
+		{"quiz", "quizzes"},
+		{"waltz", "waltzes"},
+		{"knife", "knives"},
+		{"wife", "wives"},
+		{"cafe", "cafes"},
+		{"giraffe", "giraffes"},
+		{"safe", "safes"},
+		{"leaf", "leaves"},
+		{"roof", "roofs"},
+		{"hero", "heroes"},
+		{"photo", "photos"},
+		{"person", "people"},
+		{"child", "children"},
+		{"equipment", "equipment"},
+		{"medical_record", "medical_records"},
+		{"sales_person", "sales_people"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Pluralize(tt.word); got != tt.want {
+			t.Errorf("Pluralize(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestNamingStrategies(t *testing.T) {
+	tests := []struct {
+		name          string
+		strategy      NamingStrategy
+		typeName      string
+		fieldName     string
+		table, column string
+	}{
+		{"default", DefaultNamingStrategy{}, "MedicalRecord", "FirstName", "medicalrecords", "firstname"},
+		{"snake", SnakeCaseNamingStrategy{}, "MedicalRecord", "FirstName", "medical_records", "first_name"},
+		{"snake irregular", SnakeCaseNamingStrategy{}, "Cafe", "OAuth2Token", "cafes", "oauth2_token"},
+		{"snake prefix", SnakeCaseNamingStrategy{TablePrefix: "app_"}, "Category", "PatientID", "app_categories", "patient_id"},
+		{"snake singular", SnakeCaseNamingStrategy{SingularTables: true}, "Person", "ID", "person", "id"},
+		{"identity", IdentityNamingStrategy{TablePrefix: "T"}, "Patient", "FirstName", "TPatient", "FirstName"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.strategy.TableName(tt.typeName); got != tt.table {
+				t.Errorf("TableName(%q) = %q, want %q", tt.typeName, got, tt.table)
+			}
+			if got := tt.strategy.ColumnName(tt.fieldName); got != tt.column {
+				t.Errorf("ColumnName(%q) = %q, want %q", tt.fieldName, got, tt.column)
+			}
+		})
+	}
+}
+
+func TestNamingStrategyByName(t *testing.T) {
+	for name, want := range map[string]NamingStrategy{
+		"":           DefaultNamingStrategy{},
+		"default":    DefaultNamingStrategy{},
+		"snake":      SnakeCaseNamingStrategy{TablePrefix: "app_", SingularTables: true},
+		"snake_case": SnakeCaseNamingStrategy{TablePrefix: "app_", SingularTables: true},
+		"identity":   IdentityNamingStrategy{TablePrefix: "app_"},
+	} {
+		got, err := NamingStrategyByName(name, "app_", true)
+		if err != nil || got != want {
+			t.Errorf("NamingStrategyByName(%q) = %#v, %v, want %#v", name, got, err, want)
+		}
+	}
+	if _, err := NamingStrategyByName("camel", "", false); err == nil {
+		t.Error("NamingStrategyByName(camel) succeeded, want an error")
+	}
+}
@@ -0,0 +1,43 @@
+package shared
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// IsNavigation reports whether a struct field references other entities
+// (*T, []T or []*T of a struct type) instead of mapping to a column.
+// Structs with Scan or Value methods, such as sql.NullString, are columns.
+// A field with an explicit column name in its apolon tag is always a column.
+func IsNavigation(f reflect.StructField) bool {
+	tag := f.Tag.Get("apolon")
+	if tag != "-" && strings.Split(tag, ",")[0] != "" {
+		return false
+	}
+	return NavigationTarget(f.Type) != nil
+}
+
+// NavigationTarget returns the struct type referenced by a navigation field
+// type, or nil if the type is not a navigation shape
+func NavigationTarget(t reflect.Type) reflect.Type {
+	switch t.Kind() {
+	case reflect.Ptr:
+		t = t.Elem()
+	case reflect.Slice:
+		t = t.Elem()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	default:
+		return nil
+	}
+
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return nil
+	}
+	if ptr := reflect.PointerTo(t); ptr.Implements(scannerType) || ptr.Implements(valuerType) {
+		return nil
+	}
+	return t
+}
@@ -0,0 +1,74 @@
+package shared
+
+import (
+	"reflect"
+	"sync"
+)
+
+// ModelRegistry builds the Model of each entity type once and caches it.
+// It is safe for concurrent use.
+type ModelRegistry struct {
+	naming  NamingStrategy
+	configs sync.Map // reflect.Type -> *EntityConfig
+	models  sync.Map // reflect.Type -> *registeredModel
+}
+
+// registeredModel is a cached BuildModel result
+type registeredModel struct {
+	model *Model
+	err   error
+}
+
+// DefaultRegistry caches models named with the DefaultNamingStrategy
+var DefaultRegistry = NewModelRegistry(DefaultNamingStrategy{})
+
+// NewModelRegistry creates a registry that names untagged tables and
+// columns with the given strategy
+func NewModelRegistry(naming NamingStrategy) *ModelRegistry {
+	return &ModelRegistry{naming: naming}
+}
+
+// Naming returns the registry's naming strategy
+func (r *ModelRegistry) Naming() NamingStrategy {
+	return r.naming
+}
+
+// Configure registers code-based mapping for an entity type. It replaces
+// any earlier configuration of the type and should be called before the
+// type is first used.
+func (r *ModelRegistry) Configure(cfg *EntityConfig) {
+	t := cfg.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	r.configs.Store(t, cfg)
+	r.models.Delete(t)
+}
+
+// Model returns the cached model for a type, building it on first use.
+// Tag errors are returned on every lookup together with a best-effort model.
+func (r *ModelRegistry) Model(t reflect.Type) (*Model, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if cached, ok := r.models.Load(t); ok {
+		reg := cached.(*registeredModel)
+		return reg.model, reg.err
+	}
+
+	var cfg *EntityConfig
+	if stored, ok := r.configs.Load(t); ok {
+		cfg = stored.(*EntityConfig)
+	}
+
+	model, err := BuildModel(t, r.naming, cfg)
+	cached, _ := r.models.LoadOrStore(t, &registeredModel{model: model, err: err})
+	reg := cached.(*registeredModel)
+	return reg.model, reg.err
+}
+
+// ModelOf returns the cached model for the type of v
+func (r *ModelRegistry) ModelOf(v any) (*Model, error) {
+	return r.Model(reflect.TypeOf(v))
+}
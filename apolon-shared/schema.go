@@ -1,6 +1,7 @@
 package shared
 
 import (
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
@@ -17,101 +18,148 @@ type ColumnInfo struct {
 	IsUnique     bool
 	DefaultValue *string
 	Size         int
+	Check        string // CHECK expression from the check struct tag
+	Comment      string // Column comment from the comment struct tag
+}
+
+// CheckInfo contains metadata about a named CHECK constraint
+type CheckInfo struct {
+	Name       string
+	Expression string
 }
 
 // SchemaInfo contains metadata about a database table schema
 type SchemaInfo struct {
-	Table   string
+	Table   string // Table name, schema-qualified if Schema is set
+	Schema  string // Postgres schema, empty for the search path default
 	Columns []ColumnInfo
-}
 
-// ParseSchema extracts schema metadata from a struct using reflection
-func ParseSchema(v interface{}) *SchemaInfo {
-	t := reflect.TypeOf(v)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-	}
+	// PrimaryKey lists the key columns; keys spanning several columns are
+	// declared as a table constraint
+	PrimaryKey []string
 
-	columns := []ColumnInfo{}
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		tag := f.Tag.Get("apolon")
+	Indexes     []IndexInfo
+	ForeignKeys []ForeignKeyInfo
+	Checks      []CheckInfo // Table-level checks; column checks are on Columns
+}
 
-		// Skip unexported fields
-		if !f.IsExported() {
-			continue
-		}
+// CheckConstraints returns all checks of the table, column checks first.
+// A column check is named chk_<table>_<column>.
+func (s *SchemaInfo) CheckConstraints() []CheckInfo {
+	_, table := SplitTableName(s.Table)
 
-		// Skip fields with apolon:"-"
-		if tag == "-" {
-			continue
+	var checks []CheckInfo
+	for _, col := range s.Columns {
+		if col.Check != "" {
+			checks = append(checks, CheckInfo{Name: "chk_" + table + "_" + col.Name, Expression: col.Check})
 		}
-
-		col := parseColumnInfo(f, tag)
-		columns = append(columns, col)
 	}
+	return append(checks, s.Checks...)
+}
 
-	return &SchemaInfo{
-		Table:   strings.ToLower(t.Name()) + "s",
-		Columns: columns,
-	}
+// ParseSchema extracts schema metadata from a struct using reflection
+func ParseSchema(v interface{}) *SchemaInfo {
+	return ParseSchemaWith(v, DefaultNamingStrategy{})
 }
 
-// parseColumnInfo parses a struct field into column metadata
-func parseColumnInfo(f reflect.StructField, tag string) ColumnInfo {
+// ParseSchemaWith extracts schema metadata, naming untagged tables and
+// columns with the given strategy. Invalid tags are ignored; use a
+// ModelRegistry to report them.
+func ParseSchemaWith(v interface{}, naming NamingStrategy) *SchemaInfo {
+	m, _ := BuildModel(reflect.TypeOf(v), naming, nil)
+	return m.SchemaInfo()
+}
+
+// fieldOptions holds tag options that describe the model rather than the column
+type fieldOptions struct {
+	softDelete bool
+	indexes    []indexTag
+	references string // Referenced table.column of an fk option
+	onDelete   string
+}
+
+// parseColumnInfo parses a struct field into column metadata and model
+// level options. The SQL type is only set if the tag overrides it.
+func parseColumnInfo(f reflect.StructField, tag string, naming NamingStrategy) (ColumnInfo, fieldOptions, error) {
 	col := ColumnInfo{
-		GoType: f.Type.String(),
+		GoType:  f.Type.String(),
+		Check:   f.Tag.Get("check"),
+		Comment: f.Tag.Get("comment"),
 	}
 
-	// Parse tag options
-	if tag == "" {
-		col.Name = strings.ToLower(f.Name)
-	} else {
-		parts := strings.Split(tag, ",")
-		col.Name = parts[0]
-
-		for _, opt := range parts[1:] {
-			parseTagOption(&col, opt)
-		}
+	parts := strings.Split(tag, ",")
+	col.Name = parts[0]
+	if col.Name == "" {
+		col.Name = naming.ColumnName(f.Name)
 	}
 
-	// Determine SQL type if not overridden
-	if col.SQLType == "" {
-		col.SQLType = goTypeToSQLType(f.Type, &col)
+	var opts fieldOptions
+	for _, opt := range parts[1:] {
+		if err := parseTagOption(&col, &opts, opt); err != nil {
+			return col, opts, err
+		}
 	}
 
-	return col
+	return col, opts, nil
 }
 
 // parseTagOption parses a single tag option and updates the column info
-func parseTagOption(col *ColumnInfo, opt string) {
+// or the field options
+func parseTagOption(col *ColumnInfo, opts *fieldOptions, opt string) error {
 	opt = strings.TrimSpace(opt)
 
+	if idx, ok := parseIndexTag(opt); ok {
+		opts.indexes = append(opts.indexes, idx)
+		return nil
+	}
+
 	switch {
+	case opt == "":
 	case opt == "pk":
 		col.IsPrimaryKey = true
-		// PKs are implicitly NOT NULL and UNIQUE
+		// PKs are implicitly NOT NULL; uniqueness comes from the key constraint
 		col.IsNotNull = true
-		col.IsUnique = true
 	case opt == "notnull":
 		col.IsNotNull = true
 	case opt == "unique":
 		col.IsUnique = true
+	case opt == "softdelete":
+		opts.softDelete = true
+	case strings.HasPrefix(opt, "fk:"):
+		opts.references = strings.TrimPrefix(opt, "fk:")
+		if _, _, err := parseForeignKeyTag(opts.references); err != nil {
+			return err
+		}
+	case strings.HasPrefix(opt, "ondelete:"):
+		action, err := parseOnDelete(strings.TrimPrefix(opt, "ondelete:"))
+		if err != nil {
+			return err
+		}
+		opts.onDelete = action
 	case strings.HasPrefix(opt, "default:"):
 		val := strings.TrimPrefix(opt, "default:")
 		col.DefaultValue = &val
 	case strings.HasPrefix(opt, "size:"):
 		sizeStr := strings.TrimPrefix(opt, "size:")
-		if size, err := strconv.Atoi(sizeStr); err == nil {
-			col.Size = size
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("invalid size %q", sizeStr)
 		}
+		col.Size = size
 	case strings.HasPrefix(opt, "type:"):
 		col.SQLType = strings.TrimPrefix(opt, "type:")
+		if col.SQLType == "" {
+			return fmt.Errorf("empty type option")
+		}
+	default:
+		return fmt.Errorf("unknown tag option %q", opt)
 	}
+	return nil
 }
 
-// goTypeToSQLType maps Go types to PostgreSQL types
-func goTypeToSQLType(t reflect.Type, col *ColumnInfo) string {
+// goTypeToSQLType maps Go types to PostgreSQL types. Integer columns
+// become SERIAL or BIGSERIAL if serial is set.
+func goTypeToSQLType(t reflect.Type, col *ColumnInfo, serial bool) string {
 	// Handle pointer types
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
@@ -124,12 +172,12 @@ func goTypeToSQLType(t reflect.Type, col *ColumnInfo) string {
 
 	switch t.Kind() {
 	case reflect.Int, reflect.Int32, reflect.Uint, reflect.Uint32:
-		if col.IsPrimaryKey {
+		if serial {
 			return "SERIAL"
 		}
 		return "INTEGER"
 	case reflect.Int64, reflect.Uint64:
-		if col.IsPrimaryKey {
+		if serial {
 			return "BIGSERIAL"
 		}
 		return "BIGINT"
@@ -0,0 +1,35 @@
+package shared
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckConstraints(t *testing.T) {
+	schema := &SchemaInfo{
+		Table: "clinic.visits",
+		Columns: []ColumnInfo{
+			{Name: "id"},
+			{Name: "age", Check: "age >= 0"},
+			{Name: "status", Check: "status <> ''"},
+		},
+		Checks: []CheckInfo{{Name: "chk_visit_period", Expression: "ends_at > starts_at"}},
+	}
+
+	// Column checks are named after the unqualified table and come first
+	want := []CheckInfo{
+		{Name: "chk_visits_age", Expression: "age >= 0"},
+		{Name: "chk_visits_status", Expression: "status <> ''"},
+		{Name: "chk_visit_period", Expression: "ends_at > starts_at"},
+	}
+	if got := schema.CheckConstraints(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CheckConstraints() = %+v, want %+v", got, want)
+	}
+	if len(schema.Checks) != 1 {
+		t.Errorf("CheckConstraints() modified Checks: %+v", schema.Checks)
+	}
+
+	if got := (&SchemaInfo{Table: "visits", Columns: []ColumnInfo{{Name: "id"}}}).CheckConstraints(); len(got) != 0 {
+		t.Errorf("CheckConstraints() = %+v, want none", got)
+	}
+}
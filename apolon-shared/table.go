@@ -0,0 +1,69 @@
+package shared
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Tabler is implemented by models that override their table name.
+// The name may be schema-qualified, e.g. "clinical.patients".
+type Tabler interface {
+	TableName() string
+}
+
+// TableName resolves the table name for a model type using the default
+// naming strategy
+func TableName(t reflect.Type) string {
+	return TableNameWith(t, DefaultNamingStrategy{})
+}
+
+// TableNameWith resolves the table name for a model type. A TableName method
+// takes precedence, then a `_ struct{} apolon:"table:name,schema:name"`
+// marker field, then the naming strategy.
+func TableNameWith(t reflect.Type, naming NamingStrategy) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if tabler, ok := reflect.New(t).Interface().(Tabler); ok {
+		if name := tabler.TableName(); name != "" {
+			return name
+		}
+	}
+
+	markerTag := ""
+	if marker, ok := t.FieldByName("_"); ok {
+		markerTag = marker.Tag.Get("apolon")
+	}
+	return ResolveTableName(t.Name(), markerTag, naming)
+}
+
+// ResolveTableName derives the table name from a type name and the apolon
+// tag of its `_` marker field, which may set table: and schema: options
+func ResolveTableName(typeName string, markerTag string, naming NamingStrategy) string {
+	table := naming.TableName(typeName)
+	schema := ""
+
+	for _, opt := range strings.Split(markerTag, ",") {
+		opt = strings.TrimSpace(opt)
+		switch {
+		case strings.HasPrefix(opt, "table:"):
+			table = strings.TrimPrefix(opt, "table:")
+		case strings.HasPrefix(opt, "schema:"):
+			schema = strings.TrimPrefix(opt, "schema:")
+		}
+	}
+
+	if schema != "" && !strings.Contains(table, ".") {
+		return schema + "." + table
+	}
+	return table
+}
+
+// SplitTableName splits a possibly schema-qualified table name
+func SplitTableName(name string) (schema, table string) {
+	if idx := strings.LastIndex(name, "."); idx != -1 {
+		return name[:idx], name[idx+1:]
+	}
+	return "", name
+}
@@ -0,0 +1,285 @@
+package apolon
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// ChangeSet is a serializable description of the changes SaveChanges would write
+type ChangeSet struct {
+	Entries []EntryChange `json:"entries"`
+}
+
+// EntryChange describes the pending change for a single tracked entity
+type EntryChange struct {
+	Type       string             `json:"type"`
+	Table      string             `json:"table"`
+	State      shared.EntityState `json:"state"`
+	Key        map[string]any     `json:"key,omitempty"`
+	Properties []PropertyChange   `json:"properties,omitempty"`
+}
+
+// PropertyChange describes a property written by an insert or update
+type PropertyChange struct {
+	Name     string `json:"name"`
+	Column   string `json:"column"`
+	Original any    `json:"original,omitempty"`
+	Current  any    `json:"current"`
+}
+
+// ChangeSet returns a description of every pending insert, update and
+// delete, ordered by type, key and tracking order. Unchanged entities with
+// modified properties are reported as Modified; tracked states are left
+// as they are.
+func (ct *ChangeTracker) ChangeSet() *ChangeSet {
+	set := &ChangeSet{Entries: []EntryChange{}}
+	for _, entry := range sortedEntries(ct.Entries()) {
+		if entry.rejected() {
+			continue
+		}
+
+		changed := entry.GetChangedProperties()
+		state := entry.State
+		if state == shared.Unchanged && len(changed) > 0 {
+			state = shared.Modified
+		}
+		if state == shared.Unchanged || state == shared.Detached {
+			continue
+		}
+
+		change := EntryChange{
+			Type:  entry.entityType.Name(),
+			Table: entry.model.Table,
+			State: state,
+			Key:   map[string]any{},
+		}
+
+		for _, field := range entry.model.Fields {
+			current := entry.Property(field.Name).CurrentValue()
+			if entry.model.IsKey(field) {
+				if !isZeroValue(current) {
+					change.Key[field.Column.Name] = current
+				}
+				continue
+			}
+
+			switch state {
+			case shared.Added:
+				change.Properties = append(change.Properties, PropertyChange{
+					Name:    field.Name,
+					Column:  field.Column.Name,
+					Current: current,
+				})
+			case shared.Modified:
+				if _, ok := changed[field.Name]; ok {
+					change.Properties = append(change.Properties, PropertyChange{
+						Name:     field.Name,
+						Column:   field.Column.Name,
+						Original: entry.Property(field.Name).OriginalValue(),
+						Current:  current,
+					})
+				}
+			}
+		}
+
+		set.Entries = append(set.Entries, change)
+	}
+
+	return set
+}
+
+// Apply tracks the changes described by a change set, e.g. one decoded
+// from JSON in another process, so that the next SaveChanges writes them.
+// types lists the entity types the set may contain, as values or pointers.
+// Added and Deleted entries are tracked with their key and properties;
+// Modified entries are attached with their original values and then have
+// the changed properties set and marked as modified. Decode with
+// json.Decoder.UseNumber to keep large integer keys exact.
+func (ct *ChangeTracker) Apply(set *ChangeSet, types ...any) ([]*EntityEntry, error) {
+	models := make(map[[2]string]*shared.Model, len(types))
+	for _, typ := range types {
+		model, err := ct.db.models.ModelOf(typ)
+		if err != nil {
+			return nil, fmt.Errorf("apply change set failed: %w", err)
+		}
+		models[[2]string{model.Type.Name(), model.Table}] = model
+	}
+
+	var entries []*EntityEntry
+	for _, change := range set.Entries {
+		model, ok := models[[2]string{change.Type, change.Table}]
+		if !ok {
+			return entries, fmt.Errorf("apply change set failed: no entity type %s for table %s", change.Type, change.Table)
+		}
+
+		entity := reflect.New(model.Type)
+		for column, value := range change.Key {
+			if err := setColumnValue(model, entity.Elem(), column, value); err != nil {
+				return entries, fmt.Errorf("apply change set failed: %w", err)
+			}
+		}
+
+		switch change.State {
+		case shared.Added, shared.Deleted:
+			for _, p := range change.Properties {
+				if err := setColumnValue(model, entity.Elem(), p.Column, p.Current); err != nil {
+					return entries, fmt.Errorf("apply change set failed: %w", err)
+				}
+			}
+			entries = append(entries, ct.Track(entity.Interface(), change.State))
+		case shared.Modified:
+			for _, p := range change.Properties {
+				if err := setColumnValue(model, entity.Elem(), p.Column, p.Original); err != nil {
+					return entries, fmt.Errorf("apply change set failed: %w", err)
+				}
+			}
+			entry := ct.Track(entity.Interface(), shared.Unchanged)
+			for _, p := range change.Properties {
+				if err := setColumnValue(model, entity.Elem(), p.Column, p.Current); err != nil {
+					return entries, fmt.Errorf("apply change set failed: %w", err)
+				}
+				entry.Property(model.FieldByColumn(p.Column).Name).SetModified(true)
+			}
+			entries = append(entries, entry)
+		default:
+			return entries, fmt.Errorf("apply change set failed: cannot apply %s %s", change.State, change.Type)
+		}
+	}
+	return entries, nil
+}
+
+// setColumnValue assigns a change set value to the field mapped to column.
+// Values of another type, such as float64 or string after a JSON round
+// trip, are converted through their JSON encoding.
+func setColumnValue(model *shared.Model, v reflect.Value, column string, value any) error {
+	field := model.FieldByColumn(column)
+	if field == nil {
+		return fmt.Errorf("%s has no column %s", model.Type.Name(), column)
+	}
+
+	target := v.FieldByIndex(field.Index)
+	val := reflect.ValueOf(value)
+	switch {
+	case !val.IsValid():
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	case val.Type().AssignableTo(target.Type()):
+		target.Set(val)
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("%s.%s: %w", model.Type.Name(), field.Name, err)
+	}
+	if err := json.Unmarshal(data, target.Addr().Interface()); err != nil {
+		return fmt.Errorf("%s.%s: %w", model.Type.Name(), field.Name, err)
+	}
+	return nil
+}
+
+// DebugView renders every tracked entry with its state, key and the
+// original and current value of each property
+func (ct *ChangeTracker) DebugView() string {
+	var sb strings.Builder
+
+	for _, entry := range sortedEntries(ct.Entries()) {
+		var keys []string
+		for _, pk := range entry.model.Key {
+			keys = append(keys, fmt.Sprintf("%s: %s", pk.Name,
+				formatDebugValue(entry.Property(pk.Name).CurrentValue())))
+		}
+		fmt.Fprintf(&sb, "%s {%s} %s\n", entry.entityType.Name(), strings.Join(keys, ", "), entry.State)
+
+		for _, field := range entry.model.Fields {
+			p := entry.Property(field.Name)
+			fmt.Fprintf(&sb, "    %s: %s", field.Name, formatDebugValue(p.CurrentValue()))
+			if entry.model.IsKey(field) {
+				sb.WriteString(" PK")
+			}
+			if p.IsModified() {
+				fmt.Fprintf(&sb, " Modified Originally %s", formatDebugValue(p.OriginalValue()))
+			}
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// sortedEntries orders entries by type name, then key, then tracking
+// order, for stable output
+func sortedEntries(entries []*EntityEntry) []*EntityEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		ti, tj := entries[i].entityType.Name(), entries[j].entityType.Name()
+		if ti != tj {
+			return ti < tj
+		}
+		if c := compareKeys(entries[i].KeyValues(), entries[j].KeyValues()); c != 0 {
+			return c < 0
+		}
+		return entries[i].seq < entries[j].seq
+	})
+	return entries
+}
+
+// compareKeys compares key values component by component
+func compareKeys(a, b []any) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// compareValues orders numbers numerically, times chronologically and
+// anything else by its formatted value
+func compareValues(a, b any) int {
+	va, vb := reflect.Indirect(reflect.ValueOf(a)), reflect.Indirect(reflect.ValueOf(b))
+	if va.IsValid() && vb.IsValid() && va.Kind() == vb.Kind() {
+		switch va.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return cmp.Compare(va.Int(), vb.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return cmp.Compare(va.Uint(), vb.Uint())
+		case reflect.Float32, reflect.Float64:
+			return cmp.Compare(va.Float(), vb.Float())
+		case reflect.String:
+			return strings.Compare(va.String(), vb.String())
+		}
+		ta, okA := va.Interface().(time.Time)
+		tb, okB := vb.Interface().(time.Time)
+		if okA && okB {
+			return ta.Compare(tb)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// formatDebugValue formats a property value for DebugView
+func formatDebugValue(v any) string {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		return "<null>"
+	}
+	if rv.Kind() == reflect.Ptr {
+		v = rv.Elem().Interface()
+	}
+
+	switch val := v.(type) {
+	case string:
+		return "'" + val + "'"
+	case time.Time:
+		return "'" + val.Format(time.RFC3339Nano) + "'"
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
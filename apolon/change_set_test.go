@@ -0,0 +1,101 @@
+package apolon
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+type changedInvoice struct {
+	ID       int64     `apolon:"id,pk"`
+	Customer string    `apolon:"customer"`
+	Total    float64   `apolon:"total"`
+	Lines    []string  `apolon:"lines"`
+	IssuedAt time.Time `apolon:"issued_at"`
+}
+
+func TestSortedEntries(t *testing.T) {
+	db := openTestDB(t)
+	for _, id := range []int64{10, 9, 0, 100, 0} {
+		db.Add(&changedInvoice{ID: id})
+	}
+
+	var got []int64
+	for _, entry := range sortedEntries(db.ChangeTracker.Entries()) {
+		got = append(got, entry.Entity.(*changedInvoice).ID)
+	}
+	want := []int64{0, 0, 9, 10, 100}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sorted keys = %v, want %v", got, want)
+		}
+	}
+
+	// Equal keys keep their tracking order
+	entries := sortedEntries(db.ChangeTracker.Entries())
+	if entries[0].seq > entries[1].seq {
+		t.Errorf("entries with equal keys are out of tracking order")
+	}
+}
+
+func TestChangeSetLeavesStates(t *testing.T) {
+	db := openTestDB(t)
+	invoice := &changedInvoice{ID: 1, Customer: "Ana"}
+	entry := db.Attach(invoice)
+	invoice.Customer = "Ivo"
+
+	set := db.ChangeTracker.ChangeSet()
+	if len(set.Entries) != 1 || set.Entries[0].State != shared.Modified {
+		t.Fatalf("ChangeSet() = %+v, want one Modified entry", set.Entries)
+	}
+	if entry.State != shared.Unchanged {
+		t.Errorf("ChangeSet changed the entry state to %v", entry.State)
+	}
+}
+
+func TestApplyChangeSet(t *testing.T) {
+	source := openTestDB(t)
+	issued := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	modified := &changedInvoice{ID: 1, Customer: "Ana", Total: 10, IssuedAt: issued}
+	source.Attach(modified)
+	modified.Customer = "Ivo"
+	modified.Lines = []string{"consultation"}
+	source.Add(&changedInvoice{Customer: "Mia", Total: 2.5, IssuedAt: issued})
+	source.Remove(&changedInvoice{ID: 3})
+
+	data, err := json.Marshal(source.ChangeTracker.ChangeSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var set ChangeSet
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&set); err != nil {
+		t.Fatal(err)
+	}
+
+	target := openTestDB(t)
+	entries, err := target.ChangeTracker.Apply(&set, changedInvoice{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Apply tracked %d entries, want 3", len(entries))
+	}
+	replayed, err := json.Marshal(target.ChangeTracker.ChangeSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(replayed) != string(data) {
+		t.Errorf("replayed change set\n%s\nwant\n%s", replayed, data)
+	}
+
+	if _, err := openTestDB(t).ChangeTracker.Apply(&set); err == nil || !strings.Contains(err.Error(), "changedInvoice") {
+		t.Errorf("Apply without types error = %v", err)
+	}
+}
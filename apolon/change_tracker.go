@@ -8,29 +8,169 @@ import (
 	"github.com/jkeresman01/apolon/apolon-shared"
 )
 
+// entryKey identifies a tracked entity by its type and primary key value.
+// pk is always hashable; see comparableKey.
+type entryKey struct {
+	typ reflect.Type
+	pk  any
+}
+
+// StateChangedHandler is called when a tracked entity moves between states
+type StateChangedHandler func(entry *EntityEntry, oldState, newState shared.EntityState)
+
+// TrackedHandler is called when an entity starts being tracked.
+// fromQuery is true for entities materialized by a tracking query.
+type TrackedHandler func(entry *EntityEntry, fromQuery bool)
+
+// trackerEvent is a pending notification, dispatched after the lock is released
+type trackerEvent struct {
+	entry     *EntityEntry
+	tracked   bool
+	fromQuery bool
+	oldState  shared.EntityState
+	newState  shared.EntityState
+}
+
 // ChangeTracker tracks all entity changes for a DbContext
 type ChangeTracker struct {
-	entries map[string]*EntityEntry // key: "TypeName:PKValue"
-	mu      sync.RWMutex
+	entries      map[any]*EntityEntry      // key: entity pointer, or the entry itself if rejected
+	keys         map[entryKey]*EntityEntry // key: entity type and primary key
+	db           *DB
+	stateChanged []StateChangedHandler
+	tracked      []TrackedHandler
+	seq          uint64 // sequence number of the last tracked entry
+	mu           sync.RWMutex
 }
 
-func newChangeTracker() *ChangeTracker {
+func newChangeTracker(db *DB) *ChangeTracker {
 	return &ChangeTracker{
-		entries: make(map[string]*EntityEntry),
+		db:      db,
+		entries: make(map[any]*EntityEntry),
+		keys:    make(map[entryKey]*EntityEntry),
 	}
 }
 
-// Track begins tracking an entity with the given state
-func (ct *ChangeTracker) Track(entity any, state shared.EntityState) *EntityEntry {
+// OnStateChanged registers a handler that fires whenever a tracked entity
+// changes state. Handlers run after the tracker's lock is released, so
+// they may call back into the tracker.
+func (ct *ChangeTracker) OnStateChanged(handler StateChangedHandler) {
 	ct.mu.Lock()
 	defer ct.mu.Unlock()
 
-	entry := newEntityEntry(entity, state)
-	key := ct.makeKey(entity, entry.GetPrimaryKey())
-	ct.entries[key] = entry
+	ct.stateChanged = append(ct.stateChanged, handler)
+}
+
+// OnTracked registers a handler that fires whenever an entity starts being
+// tracked. Handlers run after the tracker's lock is released.
+func (ct *ChangeTracker) OnTracked(handler TrackedHandler) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.tracked = append(ct.tracked, handler)
+}
+
+// Track begins tracking an entity with the given state. Entities must be
+// pointers to structs; anything else is rejected and SaveChanges returns
+// the error.
+func (ct *ChangeTracker) Track(entity any, state shared.EntityState) *EntityEntry {
+	return ct.track(entity, state, false)
+}
+
+// track begins tracking an entity and fires OnTracked, then OnStateChanged
+// for its move out of Detached
+func (ct *ChangeTracker) track(entity any, state shared.EntityState, fromQuery bool) *EntityEntry {
+	entry, events := ct.trackLocked(entity, state, fromQuery)
+	ct.dispatch(events)
 	return entry
 }
 
+// trackLocked adds an entry for an entity under the lock and returns the
+// events to dispatch. Entities that are not pointers to structs cannot be
+// tracked by identity; they get an entry whose error SaveChanges reports.
+func (ct *ChangeTracker) trackLocked(entity any, state shared.EntityState, fromQuery bool) (*EntityEntry, []trackerEvent) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	var events []trackerEvent
+	if err := checkEntity(entity); err != nil {
+		entry := rejectedEntry(entity, state, err)
+		entry.tracker = ct
+		entry.ref = entry
+		entry.seq = ct.nextSeq()
+		ct.entries[entry] = entry
+		return entry, append(events, trackedEvents(entry, fromQuery)...)
+	}
+
+	if existing, ok := ct.entries[entity]; ok {
+		events = append(events, ct.remove(existing))
+	}
+
+	entry := newEntityEntry(entity, state, ct.db.models)
+	entry.tracker = ct
+	entry.ref = entity
+	entry.seq = ct.nextSeq()
+	if key, ok := entry.currentKey(); ok {
+		// Another instance with the same key is replaced
+		if existing, ok := ct.keys[key]; ok {
+			events = append(events, ct.remove(existing))
+		}
+		entry.key, entry.keyed = key, true
+		ct.keys[key] = entry
+	}
+	ct.entries[entity] = entry
+	return entry, append(events, trackedEvents(entry, fromQuery)...)
+}
+
+// nextSeq returns the next tracking sequence number; the caller must hold the lock
+func (ct *ChangeTracker) nextSeq() uint64 {
+	ct.seq++
+	return ct.seq
+}
+
+// trackedEvents returns the OnTracked event for a new entry followed by its
+// move out of Detached
+func trackedEvents(entry *EntityEntry, fromQuery bool) []trackerEvent {
+	return []trackerEvent{
+		{entry: entry, tracked: true, fromQuery: fromQuery},
+		{entry: entry, oldState: shared.Detached, newState: entry.State},
+	}
+}
+
+// checkEntity returns an error unless entity is a non-nil pointer to a struct
+func checkEntity(entity any) error {
+	v := reflect.ValueOf(entity)
+	switch {
+	case !v.IsValid():
+		return fmt.Errorf("track failed: entity is nil")
+	case v.Kind() != reflect.Ptr:
+		return fmt.Errorf("track failed: %s must be tracked by pointer", v.Type())
+	case v.IsNil():
+		return fmt.Errorf("track failed: nil %s", v.Type())
+	case v.Elem().Kind() != reflect.Struct:
+		return fmt.Errorf("track failed: %s is not a pointer to a struct", v.Type())
+	}
+	return nil
+}
+
+// rejectedEntry creates an entry for an entity that cannot be tracked,
+// carrying the error for SaveChanges
+func rejectedEntry(entity any, state shared.EntityState, err error) *EntityEntry {
+	t := reflect.TypeOf(entity)
+	if t == nil {
+		t = reflect.TypeOf((*any)(nil)).Elem()
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return &EntityEntry{
+		Entity:     entity,
+		State:      state,
+		entityType: t,
+		model:      &shared.Model{Type: t},
+		modelErr:   err,
+	}
+}
+
 // TrackRange tracks multiple entities with the given state
 func (ct *ChangeTracker) TrackRange(entities any, state shared.EntityState) {
 	v := reflect.ValueOf(entities)
@@ -51,26 +191,42 @@ func (ct *ChangeTracker) GetEntry(entity any) *EntityEntry {
 	ct.mu.RLock()
 	defer ct.mu.RUnlock()
 
+	v := reflect.Indirect(reflect.ValueOf(entity))
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return nil
+	}
+
 	// First try to find by pointer identity
-	for _, entry := range ct.entries {
-		if entry.Entity == entity {
+	if reflect.TypeOf(entity).Kind() == reflect.Ptr {
+		if entry, ok := ct.entries[entity]; ok {
 			return entry
 		}
 	}
 
 	// Then try by type and PK
-	tempEntry := newEntityEntry(entity, shared.Detached)
-	key := ct.makeKey(entity, tempEntry.GetPrimaryKey())
-	return ct.entries[key]
+	model, _ := ct.db.models.Model(v.Type())
+	pk, ok := comparableKey(keyValues(model, v))
+	if !ok {
+		return nil
+	}
+	return ct.keys[entryKey{typ: model.Type, pk: pk}]
 }
 
-// GetEntryByKey returns the entry for an entity by type and primary key
-func (ct *ChangeTracker) GetEntryByKey(entityType reflect.Type, pk any) *EntityEntry {
+// GetEntryByKey returns the entry for an entity by type and primary key.
+// Composite keys are passed as one value per key field, in key order.
+func (ct *ChangeTracker) GetEntryByKey(entityType reflect.Type, keys ...any) *EntityEntry {
 	ct.mu.RLock()
 	defer ct.mu.RUnlock()
 
-	key := fmt.Sprintf("%s:%v", entityType.Name(), pk)
-	return ct.entries[key]
+	model, _ := ct.db.models.Model(entityType)
+	if len(keys) != len(model.Key) {
+		return nil
+	}
+	pk, ok := comparableKey(normalizeKey(model, keys))
+	if !ok {
+		return nil
+	}
+	return ct.keys[entryKey{typ: model.Type, pk: pk}]
 }
 
 // Entries returns all tracked entries
@@ -119,59 +275,207 @@ func (ct *ChangeTracker) HasChanges() bool {
 
 // DetectChanges scans all tracked entities for changes
 func (ct *ChangeTracker) DetectChanges() {
+	ct.dispatch(ct.detectChangesLocked())
+}
+
+// detectChangesLocked updates entry states under the lock and returns the
+// events to dispatch
+func (ct *ChangeTracker) detectChangesLocked() []trackerEvent {
 	ct.mu.Lock()
 	defer ct.mu.Unlock()
 
+	var events []trackerEvent
 	for _, entry := range ct.entries {
+		if entry.rejected() {
+			continue
+		}
+		oldState := entry.State
 		entry.DetectChanges()
+		events = append(events, ct.rekey(entry)...)
+		if entry.State != oldState {
+			events = append(events, trackerEvent{entry: entry, oldState: oldState, newState: entry.State})
+		}
 	}
+	return events
 }
 
 // AcceptAllChanges marks all entities as unchanged
 func (ct *ChangeTracker) AcceptAllChanges() {
+	ct.dispatch(ct.acceptAllChangesLocked())
+}
+
+// acceptAllChangesLocked accepts changes under the lock and returns the
+// events to dispatch
+func (ct *ChangeTracker) acceptAllChangesLocked() []trackerEvent {
 	ct.mu.Lock()
 	defer ct.mu.Unlock()
 
-	for key, entry := range ct.entries {
+	var events []trackerEvent
+	for _, entry := range ct.entries {
 		if entry.State == shared.Deleted {
-			delete(ct.entries, key)
-		} else {
-			entry.AcceptChanges()
+			events = append(events, ct.remove(entry))
+			continue
+		}
+		if entry.rejected() {
+			continue
+		}
+
+		oldState := entry.State
+		entry.AcceptChanges()
+		// Inserts may have received a generated primary key
+		events = append(events, ct.rekey(entry)...)
+		if oldState != shared.Unchanged {
+			events = append(events, trackerEvent{entry: entry, oldState: oldState, newState: shared.Unchanged})
 		}
 	}
+	return events
 }
 
 // Clear removes all tracked entities
 func (ct *ChangeTracker) Clear() {
+	ct.dispatch(ct.clearLocked())
+}
+
+// clearLocked removes all entries under the lock and returns the events
+// to dispatch
+func (ct *ChangeTracker) clearLocked() []trackerEvent {
 	ct.mu.Lock()
 	defer ct.mu.Unlock()
 
-	ct.entries = make(map[string]*EntityEntry)
+	events := make([]trackerEvent, 0, len(ct.entries))
+	for _, entry := range ct.entries {
+		events = append(events, ct.remove(entry))
+	}
+	ct.entries = make(map[any]*EntityEntry)
+	ct.keys = make(map[entryKey]*EntityEntry)
+	return events
 }
 
 // Untrack stops tracking an entity
 func (ct *ChangeTracker) Untrack(entity any) {
+	ct.dispatch(ct.untrackLocked(entity))
+}
+
+// untrackLocked removes an entity's entry under the lock and returns the
+// events to dispatch
+func (ct *ChangeTracker) untrackLocked(entity any) []trackerEvent {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	v := reflect.Indirect(reflect.ValueOf(entity))
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	if reflect.TypeOf(entity).Kind() == reflect.Ptr {
+		if entry, ok := ct.entries[entity]; ok {
+			return []trackerEvent{ct.remove(entry)}
+		}
+	}
+
+	model, _ := ct.db.models.Model(v.Type())
+	pk, ok := comparableKey(keyValues(model, v))
+	if !ok {
+		return nil
+	}
+	if entry, ok := ct.keys[entryKey{typ: model.Type, pk: pk}]; ok {
+		return []trackerEvent{ct.remove(entry)}
+	}
+	return nil
+}
+
+// setState moves a tracked entry to a new state and fires OnStateChanged
+func (ct *ChangeTracker) setState(entry *EntityEntry, state shared.EntityState) {
+	ct.dispatch(ct.setStateLocked(entry, state, nil))
+}
+
+// markModified moves an Unchanged entry to Modified and fires OnStateChanged
+func (ct *ChangeTracker) markModified(entry *EntityEntry) {
+	ct.dispatch(ct.setStateLocked(entry, shared.Modified, func(state shared.EntityState) bool {
+		return state == shared.Unchanged
+	}))
+}
+
+// setStateLocked changes the entry's state under the lock if allowed
+// reports true for its current state, and returns the event to dispatch
+func (ct *ChangeTracker) setStateLocked(entry *EntityEntry, state shared.EntityState, allowed func(shared.EntityState) bool) []trackerEvent {
 	ct.mu.Lock()
 	defer ct.mu.Unlock()
 
-	tempEntry := newEntityEntry(entity, shared.Detached)
-	key := ct.makeKey(entity, tempEntry.GetPrimaryKey())
-	delete(ct.entries, key)
+	oldState := entry.State
+	if oldState == state || (allowed != nil && !allowed(oldState)) {
+		return nil
+	}
+	entry.State = state
+	return []trackerEvent{{entry: entry, oldState: oldState, newState: state}}
 }
 
-// makeKey creates a unique key for an entity based on type and PK
-// For new entities (zero PK), uses pointer address to avoid collisions
-func (ct *ChangeTracker) makeKey(entity any, pk any) string {
-	t := reflect.TypeOf(entity)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
+// remove drops an entry from both indexes and detaches it, returning the
+// state change event; the caller must hold the lock
+func (ct *ChangeTracker) remove(entry *EntityEntry) trackerEvent {
+	delete(ct.entries, entry.ref)
+	if entry.keyed && ct.keys[entry.key] == entry {
+		delete(ct.keys, entry.key)
 	}
+	entry.keyed = false
+	entry.tracker = nil
 
-	// Check if PK is zero value - if so, use pointer address
-	if isZeroValue(pk) {
-		return fmt.Sprintf("%s:ptr:%p", t.Name(), entity)
+	event := trackerEvent{entry: entry, oldState: entry.State, newState: shared.Detached}
+	entry.State = shared.Detached
+	return event
+}
+
+// dispatch runs registered handlers for the given events; the caller must
+// not hold the lock
+func (ct *ChangeTracker) dispatch(events []trackerEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	ct.mu.RLock()
+	stateChanged := ct.stateChanged
+	tracked := ct.tracked
+	ct.mu.RUnlock()
+
+	for _, event := range events {
+		if event.tracked {
+			for _, handler := range tracked {
+				handler(event.entry, event.fromQuery)
+			}
+			continue
+		}
+		if event.oldState == event.newState {
+			continue
+		}
+		for _, handler := range stateChanged {
+			handler(event.entry, event.oldState, event.newState)
+		}
+	}
+}
+
+// rekey moves an entry to its current primary key in the key index and,
+// like Track, detaches another instance already tracked under that key.
+// It returns the events to dispatch; the caller must hold the lock.
+func (ct *ChangeTracker) rekey(entry *EntityEntry) []trackerEvent {
+	key, ok := entry.currentKey()
+	if entry.keyed && ok && key == entry.key {
+		return nil
+	}
+
+	if entry.keyed && ct.keys[entry.key] == entry {
+		delete(ct.keys, entry.key)
+	}
+	entry.key, entry.keyed = key, ok
+	if !ok {
+		return nil
+	}
+
+	var events []trackerEvent
+	if existing, found := ct.keys[key]; found && existing != entry {
+		events = append(events, ct.remove(existing))
 	}
-	return fmt.Sprintf("%s:%v", t.Name(), pk)
+	ct.keys[key] = entry
+	return events
 }
 
 // GetOrTrack returns an existing entry or creates a new one
@@ -181,3 +485,33 @@ func (ct *ChangeTracker) GetOrTrack(entity any, state shared.EntityState) *Entit
 	}
 	return ct.Track(entity, state)
 }
+
+// normalizeKey converts numeric key values to the types of the model's key
+// fields, so Find(1) matches an int64 key
+func normalizeKey(model *shared.Model, keys []any) []any {
+	normalized := make([]any, len(keys))
+	for i, pk := range keys {
+		normalized[i] = pk
+		if i >= len(model.Key) {
+			continue
+		}
+
+		field := model.Key[i]
+		v := reflect.ValueOf(pk)
+		if v.IsValid() && v.Type() != field.Type && isNumericKind(v.Kind()) && isNumericKind(field.Type.Kind()) {
+			normalized[i] = v.Convert(field.Type).Interface()
+		}
+	}
+	return normalized
+}
+
+// isNumericKind reports whether k is an integer or floating point kind
+func isNumericKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
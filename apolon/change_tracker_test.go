@@ -0,0 +1,227 @@
+package apolon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+type trackedPatient struct {
+	ID   int      `apolon:"id,pk"`
+	Name string   `apolon:"name"`
+	Tags []string `apolon:"tags"`
+}
+
+type trackedCode struct {
+	Key  []byte `apolon:"key,pk"`
+	Name string `apolon:"name"`
+}
+
+type trackedLine struct {
+	OrderID int `apolon:"order_id,pk"`
+	LineNo  int `apolon:"line_no,pk"`
+	Qty     int `apolon:"qty"`
+}
+
+// openTestDB opens a DB without connecting; statements are never executed
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open("postgres://localhost/apolon_test?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestTrackKeysByPointerAndKey(t *testing.T) {
+	db := openTestDB(t)
+	ct := db.ChangeTracker
+
+	patient := &trackedPatient{ID: 1, Tags: []string{"a"}}
+	entry := db.Attach(patient)
+	if got := ct.GetEntry(patient); got != entry {
+		t.Fatalf("GetEntry by pointer = %v, want %v", got, entry)
+	}
+	if got := ct.GetEntry(&trackedPatient{ID: 1}); got != entry {
+		t.Errorf("GetEntry by key = %v, want %v", got, entry)
+	}
+	if got := ct.GetEntryByKey(entry.entityType, int64(1)); got != entry {
+		t.Errorf("GetEntryByKey(int64) = %v, want %v", got, entry)
+	}
+
+	// Another instance with the same key replaces the first
+	replacement := &trackedPatient{ID: 1}
+	db.Attach(replacement)
+	if entry.State != shared.Detached {
+		t.Errorf("replaced entry state = %v, want Detached", entry.State)
+	}
+	if n := len(ct.Entries()); n != 1 {
+		t.Errorf("tracked %d entries, want 1", n)
+	}
+}
+
+func TestRekeyDetachesEntryWithSameKey(t *testing.T) {
+	db := openTestDB(t)
+	ct := db.ChangeTracker
+
+	first := db.Attach(&trackedPatient{ID: 1})
+	second := &trackedPatient{ID: 2}
+	secondEntry := db.Attach(second)
+
+	var events []string
+	ct.OnStateChanged(func(entry *EntityEntry, oldState, newState shared.EntityState) {
+		events = append(events, oldState.String()+"->"+newState.String())
+	})
+
+	// Changing the key onto a tracked one replaces it, as Track does
+	second.ID = 1
+	ct.DetectChanges()
+	if got := ct.GetEntry(&trackedPatient{ID: 1}); got != secondEntry {
+		t.Errorf("GetEntry by key = %v, want the re-keyed entry", got)
+	}
+	if ct.GetEntry(&trackedPatient{ID: 2}) != nil {
+		t.Error("the old key still resolves")
+	}
+	if first.State != shared.Detached {
+		t.Errorf("replaced entry state = %v, want Detached", first.State)
+	}
+	if n := len(ct.Entries()); n != 1 {
+		t.Errorf("tracked %d entries, want 1", n)
+	}
+	if !strings.Contains(strings.Join(events, ","), "Unchanged->Detached") {
+		t.Errorf("events = %v, want the replaced entry detached", events)
+	}
+}
+
+func TestTrackByteSliceKey(t *testing.T) {
+	db := openTestDB(t)
+	ct := db.ChangeTracker
+
+	code := &trackedCode{Key: []byte("a")}
+	entry := db.Add(code)
+	if got := ct.GetEntry(&trackedCode{Key: []byte("a")}); got != entry {
+		t.Errorf("GetEntry by []byte key = %v, want %v", got, entry)
+	}
+	if got := ct.GetEntryByKey(entry.entityType, []byte("b")); got != nil {
+		t.Errorf("GetEntryByKey of another key = %v, want nil", got)
+	}
+}
+
+func TestTrackCompositeKey(t *testing.T) {
+	db := openTestDB(t)
+	ct := db.ChangeTracker
+
+	entry := db.Attach(&trackedLine{OrderID: 1, LineNo: 2})
+	db.Attach(&trackedLine{OrderID: 2, LineNo: 1})
+	if got := ct.GetEntryByKey(entry.entityType, 1, 2); got != entry {
+		t.Errorf("GetEntryByKey(1, 2) = %v, want %v", got, entry)
+	}
+	if got := ct.GetEntryByKey(entry.entityType, 1); got != nil {
+		t.Errorf("GetEntryByKey with a missing component = %v, want nil", got)
+	}
+}
+
+func TestTrackRejectsNonPointers(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, entity := range []any{trackedPatient{ID: 1, Tags: []string{"a"}}, nil, (*trackedPatient)(nil)} {
+		entry := db.Add(entity)
+		if entry.modelErr == nil {
+			t.Errorf("Add(%#v) was accepted", entity)
+		}
+	}
+
+	_, err := db.SaveChanges()
+	if err == nil || !strings.Contains(err.Error(), "track failed") {
+		t.Errorf("SaveChanges() error = %v, want a track error", err)
+	}
+
+	db.ChangeTracker.Clear()
+	if n := len(db.ChangeTracker.Entries()); n != 0 {
+		t.Errorf("tracked %d entries after Clear, want 0", n)
+	}
+}
+
+func TestTrackerUsableAfterHandlerPanic(t *testing.T) {
+	db := openTestDB(t)
+	db.ChangeTracker.OnTracked(func(*EntityEntry, bool) { panic("handler") })
+
+	func() {
+		defer func() { recover() }()
+		db.Add(&trackedPatient{ID: 1})
+	}()
+
+	// The lock was released before handlers ran
+	if n := len(db.ChangeTracker.Entries()); n != 1 {
+		t.Errorf("tracked %d entries, want 1", n)
+	}
+}
+
+func TestComparableKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []any
+		want   any
+		ok     bool
+	}{
+		{"none", nil, nil, false},
+		{"single", []any{1}, 1, true},
+		{"zero", []any{0}, nil, false},
+		{"bytes", []any{[]byte("ab")}, "ab", true},
+		{"slice", []any{[]string{"a"}}, nil, false},
+		{"composite", []any{1, "x"}, [2]any{1, "x"}, true},
+		{"composite zero", []any{1, ""}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := comparableKey(tt.values)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("comparableKey(%v) = %v, %v, want %v, %v", tt.values, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestStateChangedEvents(t *testing.T) {
+	db := openTestDB(t)
+
+	var events []string
+	db.ChangeTracker.OnStateChanged(func(entry *EntityEntry, oldState, newState shared.EntityState) {
+		// Handlers may call back into the tracker
+		db.ChangeTracker.Entries()
+		events = append(events, oldState.String()+"->"+newState.String())
+	})
+
+	patient := &trackedPatient{ID: 1}
+	db.Add(patient)
+	db.Attach(&trackedPatient{ID: 2})
+	entry := db.ChangeTracker.GetEntry(&trackedPatient{ID: 2})
+	entry.Property("Name").SetModified(true)
+	entry.Property("Name").SetModified(true)
+	db.Remove(patient)
+
+	want := []string{"Detached->Added", "Detached->Unchanged", "Unchanged->Modified", "Added->Deleted"}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestSetStateConcurrently(t *testing.T) {
+	db := openTestDB(t)
+	entry := db.Attach(&trackedPatient{ID: 1})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			db.ChangeTracker.DetectChanges()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		db.Update(entry.Entity)
+	}
+	<-done
+}
@@ -1,10 +1,12 @@
 package apolon
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/jkeresman01/apolon/apolon-shared"
 	_ "github.com/lib/pq"
@@ -14,18 +16,58 @@ import (
 type DB struct {
 	conn          *sql.DB
 	ChangeTracker *ChangeTracker
+	filters       *filterRegistry
+	models        *shared.ModelRegistry
+	strictColumns bool // reject result columns without a matching field
+
+	// migrationLockTimeout bounds the wait for the migration advisory lock
+	migrationLockTimeout time.Duration
+
+	// Set by options and consumed by Open to build models
+	naming    shared.NamingStrategy
+	configure []func(mb *ModelBuilder)
 }
 
 // Open creates a new database connection with change tracking enabled
-func Open(dsn string) (*DB, error) {
+func Open(dsn string, opts ...Option) (*DB, error) {
 	conn, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
-	return &DB{
-		conn:          conn,
-		ChangeTracker: newChangeTracker(),
-	}, nil
+	db := &DB{
+		conn:    conn,
+		filters: newFilterRegistry(),
+	}
+	db.ChangeTracker = newChangeTracker(db)
+	for _, opt := range opts {
+		opt(db)
+	}
+	db.models = db.buildModels()
+	return db, nil
+}
+
+// buildModels creates the model registry for the configured naming
+// strategy and OnModelCreating callbacks; unconfigured DBs share the
+// default registry
+func (apolon *DB) buildModels() *shared.ModelRegistry {
+	if apolon.naming == nil && len(apolon.configure) == 0 {
+		return shared.DefaultRegistry
+	}
+
+	naming := apolon.naming
+	if naming == nil {
+		naming = shared.DefaultNamingStrategy{}
+	}
+	models := shared.NewModelRegistry(naming)
+
+	mb := newModelBuilder()
+	for _, configure := range apolon.configure {
+		configure(mb)
+	}
+	for _, config := range mb.configs {
+		models.Configure(config)
+	}
+	return models
 }
 
 // Close closes the database connection
@@ -59,7 +101,8 @@ func (apolon *DB) AddRange(entities ...any) {
 func (apolon *DB) Update(entity any) *EntityEntry {
 	entry := apolon.ChangeTracker.GetEntry(entity)
 	if entry != nil {
-		entry.State = shared.Modified
+		apolon.ChangeTracker.setState(entry, shared.Modified)
+		entry.markAllModified()
 		return entry
 	}
 	return apolon.ChangeTracker.Track(entity, shared.Modified)
@@ -69,7 +112,7 @@ func (apolon *DB) Update(entity any) *EntityEntry {
 func (apolon *DB) Remove(entity any) *EntityEntry {
 	entry := apolon.ChangeTracker.GetEntry(entity)
 	if entry != nil {
-		entry.State = shared.Deleted
+		apolon.ChangeTracker.setState(entry, shared.Deleted)
 		return entry
 	}
 	return apolon.ChangeTracker.Track(entity, shared.Deleted)
@@ -99,16 +142,24 @@ func (apolon *DB) SaveChanges() (int, error) {
 
 // SaveChangesContext persists all tracked changes within an optional transaction
 func (apolon *DB) SaveChangesContext(tx *sql.Tx) (int, error) {
+	return apolon.SaveChangesWithContext(context.Background(), tx)
+}
+
+// SaveChangesWithContext persists all tracked changes within an optional
+// transaction, evaluating query filters against ctx
+func (apolon *DB) SaveChangesWithContext(ctx context.Context, tx *sql.Tx) (int, error) {
 	apolon.ChangeTracker.DetectChanges()
 
-	var execer interface {
-		Exec(query string, args ...any) (sql.Result, error)
+	for _, entry := range apolon.ChangeTracker.Entries() {
+		if entry.modelErr != nil {
+			return 0, entry.modelErr
+		}
 	}
 
 	ownTx := false
 	if tx == nil {
 		var err error
-		tx, err = apolon.conn.Begin()
+		tx, err = apolon.conn.BeginTx(ctx, nil)
 		if err != nil {
 			return 0, fmt.Errorf("failed to begin transaction: %w", err)
 		}
@@ -119,22 +170,23 @@ func (apolon *DB) SaveChangesContext(tx *sql.Tx) (int, error) {
 			}
 		}()
 	}
-	execer = tx
 
 	affected := 0
 
-	// Process Deleted entities first (to avoid FK issues)
-	for _, entry := range apolon.ChangeTracker.EntriesByState(shared.Deleted) {
-		n, err := apolon.executeDelete(execer, entry)
+	// Process Deleted entities first (to avoid FK issues), dependents before principals
+	deleted := orderByDependencies(apolon.ChangeTracker.EntriesByState(shared.Deleted))
+	for i := len(deleted) - 1; i >= 0; i-- {
+		entry := deleted[i]
+		n, err := apolon.executeDelete(ctx, tx, entry)
 		if err != nil {
 			return affected, err
 		}
 		affected += n
 	}
 
-	// Process Added entities
-	for _, entry := range apolon.ChangeTracker.EntriesByState(shared.Added) {
-		n, err := apolon.executeInsert(execer, entry)
+	// Process Added entities, principals before dependents
+	for _, entry := range orderByDependencies(apolon.ChangeTracker.EntriesByState(shared.Added)) {
+		n, err := apolon.executeInsert(ctx, tx, entry)
 		if err != nil {
 			return affected, err
 		}
@@ -143,7 +195,7 @@ func (apolon *DB) SaveChangesContext(tx *sql.Tx) (int, error) {
 
 	// Process Modified entities
 	for _, entry := range apolon.ChangeTracker.EntriesByState(shared.Modified) {
-		n, err := apolon.executeUpdate(execer, entry)
+		n, err := apolon.executeUpdate(ctx, tx, entry)
 		if err != nil {
 			return affected, err
 		}
@@ -153,7 +205,7 @@ func (apolon *DB) SaveChangesContext(tx *sql.Tx) (int, error) {
 	// Also check Unchanged entities that may have changes
 	for _, entry := range apolon.ChangeTracker.EntriesByState(shared.Unchanged) {
 		if entry.HasChanges() {
-			n, err := apolon.executeUpdate(execer, entry)
+			n, err := apolon.executeUpdate(ctx, tx, entry)
 			if err != nil {
 				return affected, err
 			}
@@ -172,57 +224,62 @@ func (apolon *DB) SaveChangesContext(tx *sql.Tx) (int, error) {
 	return affected, nil
 }
 
+// execer is the subset of *sql.DB, *sql.Tx and *sql.Conn used to run statements
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 // executeInsert generates and executes an INSERT statement
-func (apolon *DB) executeInsert(execer interface {
-	Exec(string, ...any) (sql.Result, error)
-}, entry *EntityEntry) (int, error) {
-	info := shared.ParseModel(entry.Entity)
-	v := reflect.ValueOf(entry.Entity)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+func (apolon *DB) executeInsert(ctx context.Context, ex execer, entry *EntityEntry) (int, error) {
+	entry.fixupForeignKeys()
+	if _, err := apolon.applyFilters(ctx, entry); err != nil {
+		return 0, err
 	}
 
+	model := entry.model
+	v := entry.fieldValue()
+
+	// Only single-column keys are generated by the database
+	generated := len(model.Key) == 1
+
 	cols := []string{}
 	vals := []any{}
 	placeholders := []string{}
 
-	for i, col := range info.Fields {
+	for _, field := range model.Fields {
+		val := v.FieldByIndex(field.Index).Interface()
 		// Skip auto-increment PK (if value is zero)
-		if entry.pkField != "" && entry.entityType.Field(i).Name == entry.pkField {
-			pkVal := v.Field(i).Interface()
-			if isZeroValue(pkVal) {
-				continue
-			}
+		if generated && model.IsKey(field) && isZeroValue(val) {
+			continue
 		}
-		cols = append(cols, col)
-		vals = append(vals, v.Field(i).Interface())
+		cols = append(cols, field.Column.Name)
+		vals = append(vals, val)
 		placeholders = append(placeholders, fmt.Sprintf("$%d", len(vals)))
 	}
 
 	query := fmt.Sprintf(
 		"INSERT INTO %s (%s) VALUES (%s)",
-		info.Table,
+		model.Table,
 		strings.Join(cols, ", "),
 		strings.Join(placeholders, ", "),
 	)
 
 	// If PK was skipped, use RETURNING to get the generated value
-	if entry.pkField != "" {
-		pkColName := getPKColumnName(entry.Entity, entry.pkField)
-		if pkColName != "" {
-			query += fmt.Sprintf(" RETURNING %s", pkColName)
-			var newPK any
-			err := apolon.conn.QueryRow(query, vals...).Scan(&newPK)
-			if err != nil {
-				return 0, fmt.Errorf("insert failed: %w", err)
-			}
-			// Set the new PK value on the entity
-			setPKValue(entry.Entity, entry.pkField, newPK)
-			return 1, nil
+	if generated {
+		pk := model.Key[0]
+		query += fmt.Sprintf(" RETURNING %s", pk.Column.Name)
+		var newPK any
+		err := ex.QueryRowContext(ctx, query, vals...).Scan(&newPK)
+		if err != nil {
+			return 0, fmt.Errorf("insert failed: %w", err)
 		}
+		// Set the new PK value on the entity
+		setPKValue(entry.Entity, pk, newPK)
+		return 1, nil
 	}
 
-	result, err := execer.Exec(query, vals...)
+	result, err := ex.ExecContext(ctx, query, vals...)
 	if err != nil {
 		return 0, fmt.Errorf("insert failed: %w", err)
 	}
@@ -235,48 +292,53 @@ func (apolon *DB) executeInsert(execer interface {
 }
 
 // executeUpdate generates and executes an UPDATE statement
-func (apolon *DB) executeUpdate(execer interface {
-	Exec(string, ...any) (sql.Result, error)
-}, entry *EntityEntry) (int, error) {
+func (apolon *DB) executeUpdate(ctx context.Context, ex execer, entry *EntityEntry) (int, error) {
+	entry.fixupForeignKeys()
 	changed := entry.GetChangedProperties()
 	if len(changed) == 0 {
 		return 0, nil
 	}
 
-	info := shared.ParseModel(entry.Entity)
-	v := reflect.ValueOf(entry.Entity)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+	model := entry.model
+	if len(model.Key) == 0 {
+		return 0, fmt.Errorf("update failed: %s has no primary key", model.Type.Name())
 	}
 
+	filters, err := apolon.applyFilters(ctx, entry)
+	if err != nil {
+		return 0, err
+	}
+
+	v := entry.fieldValue()
+
 	// Build SET clause with only changed columns
 	setClauses := []string{}
 	vals := []any{}
 	paramIdx := 1
 
-	for i, col := range info.Fields {
-		fieldName := entry.entityType.Field(i).Name
-		if _, isChanged := changed[fieldName]; isChanged {
-			setClauses = append(setClauses, fmt.Sprintf("%s = $%d", col, paramIdx))
-			vals = append(vals, v.Field(i).Interface())
+	for _, field := range model.Fields {
+		if _, isChanged := changed[field.Name]; isChanged {
+			setClauses = append(setClauses, fmt.Sprintf("%s = $%d", field.Column.Name, paramIdx))
+			vals = append(vals, v.FieldByIndex(field.Index).Interface())
 			paramIdx++
 		}
 	}
+	if len(setClauses) == 0 {
+		return 0, nil
+	}
 
-	// Add PK to WHERE clause
-	pkColName := getPKColumnName(entry.Entity, entry.pkField)
-	pkValue := entry.GetPrimaryKey()
-	vals = append(vals, pkValue)
+	// Add PK and query filters to WHERE clause
+	where, whereArgs := keyPredicate(model.Key, entry.KeyValues(), filters, paramIdx)
+	vals = append(vals, whereArgs...)
 
 	query := fmt.Sprintf(
-		"UPDATE %s SET %s WHERE %s = $%d",
-		info.Table,
+		"UPDATE %s SET %s WHERE %s",
+		model.Table,
 		strings.Join(setClauses, ", "),
-		pkColName,
-		paramIdx,
+		where,
 	)
 
-	result, err := execer.Exec(query, vals...)
+	result, err := ex.ExecContext(ctx, query, vals...)
 	if err != nil {
 		return 0, fmt.Errorf("update failed: %w", err)
 	}
@@ -288,17 +350,27 @@ func (apolon *DB) executeUpdate(execer interface {
 	return int(n), nil
 }
 
-// executeDelete generates and executes a DELETE statement
-func (apolon *DB) executeDelete(execer interface {
-	Exec(string, ...any) (sql.Result, error)
-}, entry *EntityEntry) (int, error) {
-	info := shared.ParseModel(entry.Entity)
-	pkColName := getPKColumnName(entry.Entity, entry.pkField)
-	pkValue := entry.GetPrimaryKey()
+// executeDelete generates and executes a DELETE statement, or an UPDATE of
+// the deletion timestamp for soft-deleted models
+func (apolon *DB) executeDelete(ctx context.Context, ex execer, entry *EntityEntry) (int, error) {
+	model := entry.model
+	if len(model.Key) == 0 {
+		return 0, fmt.Errorf("delete failed: %s has no primary key", model.Type.Name())
+	}
+
+	filters, err := apolon.applyFilters(ctx, entry)
+	if err != nil {
+		return 0, err
+	}
+
+	if model.SoftDelete != nil {
+		return apolon.executeSoftDelete(ctx, ex, entry, filters)
+	}
 
-	query := fmt.Sprintf("DELETE FROM %s WHERE %s = $1", info.Table, pkColName)
+	where, args := keyPredicate(model.Key, entry.KeyValues(), filters, 1)
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", model.Table, where)
 
-	result, err := execer.Exec(query, pkValue)
+	result, err := ex.ExecContext(ctx, query, args...)
 	if err != nil {
 		return 0, fmt.Errorf("delete failed: %w", err)
 	}
@@ -310,36 +382,63 @@ func (apolon *DB) executeDelete(execer interface {
 	return int(n), nil
 }
 
-// getPKColumnName returns the database column name for the PK field
-func getPKColumnName(entity any, pkField string) string {
-	t := reflect.TypeOf(entity)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
+// executeSoftDelete stamps the soft delete column instead of removing the row
+func (apolon *DB) executeSoftDelete(ctx context.Context, ex execer, entry *EntityEntry,
+	filters []shared.Condition) (int, error) {
+	model := entry.model
+	now := time.Now()
+
+	where, whereArgs := keyPredicate(model.Key, entry.KeyValues(), filters, 2)
+	query := fmt.Sprintf(
+		"UPDATE %s SET %s = $1 WHERE %s",
+		model.Table,
+		model.SoftDelete.Column.Name,
+		where,
+	)
+
+	result, err := ex.ExecContext(ctx, query, append([]any{now}, whereArgs...)...)
+	if err != nil {
+		return 0, fmt.Errorf("soft delete failed: %w", err)
 	}
 
-	field, ok := t.FieldByName(pkField)
-	if !ok {
-		return strings.ToLower(pkField)
+	setTimeValue(entry.Entity, model.SoftDelete, now)
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return int(n), nil
+}
+
+// keyPredicate builds "pk = $n" for every key column followed by any
+// filter conditions
+func keyPredicate(key []*shared.Field, values []any, filters []shared.Condition, paramIdx int) (string, []any) {
+	parts := make([]string, 0, len(key)+len(filters))
+	args := make([]any, 0, len(key))
+	for i, field := range key {
+		parts = append(parts, fmt.Sprintf("%s = $%d", field.Column.Name, paramIdx))
+		args = append(args, values[i])
+		paramIdx++
 	}
 
-	tag := field.Tag.Get("apolon")
-	if tag == "" {
-		return strings.ToLower(pkField)
+	for _, cond := range filters {
+		sql, condArgs, nextIdx := cond.ToSQL(paramIdx)
+		parts = append(parts, sql)
+		args = append(args, condArgs...)
+		paramIdx = nextIdx
 	}
 
-	// Get column name (before comma)
-	parts := strings.Split(tag, ",")
-	return parts[0]
+	return strings.Join(parts, " AND "), args
 }
 
 // setPKValue sets the primary key value on an entity using reflection
-func setPKValue(entity any, pkField string, value any) {
+func setPKValue(entity any, pkField *shared.Field, value any) {
 	v := reflect.ValueOf(entity)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
 
-	field := v.FieldByName(pkField)
+	field := v.FieldByIndex(pkField.Index)
 	if field.IsValid() && field.CanSet() {
 		val := reflect.ValueOf(value)
 		if val.Type().ConvertibleTo(field.Type()) {
@@ -348,6 +447,26 @@ func setPKValue(entity any, pkField string, value any) {
 	}
 }
 
+// setTimeValue sets a time.Time or *time.Time field on an entity
+func setTimeValue(entity any, timeField *shared.Field, value time.Time) {
+	v := reflect.ValueOf(entity)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	field := v.FieldByIndex(timeField.Index)
+	if !field.IsValid() || !field.CanSet() {
+		return
+	}
+
+	switch field.Type() {
+	case reflect.TypeOf(value):
+		field.Set(reflect.ValueOf(value))
+	case reflect.TypeOf(&value):
+		field.Set(reflect.ValueOf(&value))
+	}
+}
+
 // isZeroValue checks if a value is the zero value for its type
 func isZeroValue(v any) bool {
 	return reflect.ValueOf(v).IsZero()
@@ -0,0 +1,158 @@
+package apolon
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDriver records the statements run through it and answers every
+// query with the same canned rows, so SQL can be checked without a database
+type fakeDriver struct {
+	mu         sync.Mutex
+	statements []string
+	columns    []string
+	rows       [][]driver.Value
+}
+
+// openFakeDB opens a DB whose connection goes to a fakeDriver
+func openFakeDB(t *testing.T, opts ...Option) (*DB, *fakeDriver) {
+	t.Helper()
+	db, err := Open("postgres://localhost/apolon_test?sslmode=disable", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.conn.Close()
+
+	fake := &fakeDriver{}
+	db.conn = sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+// Statements returns the statements run so far
+func (f *fakeDriver) Statements() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.statements...)
+}
+
+// SetRows sets the result of every following query
+func (f *fakeDriver) SetRows(columns []string, rows ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.columns, f.rows = columns, rows
+}
+
+func (f *fakeDriver) record(query string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statements = append(f.statements, query)
+}
+
+func (f *fakeDriver) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDriver) Driver() driver.Driver                        { return f }
+func (f *fakeDriver) Open(string) (driver.Conn, error)             { return fakeConn{f}, nil }
+
+type fakeConn struct{ driver *fakeDriver }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fake driver: prepared statements are not supported")
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.driver.record(query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.driver.record(query)
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+	return &fakeRows{columns: c.driver.columns, rows: c.driver.rows}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestRemoveSoftDeletes(t *testing.T) {
+	db, fake := openFakeDB(t)
+
+	visit := &filteredVisit{ID: 1, Status: "open"}
+	db.Attach(visit)
+	db.Remove(visit)
+	if _, err := db.SaveChanges(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"UPDATE filteredvisits SET deleted_at = $1 WHERE id = $2"}
+	if got := fake.Statements(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("statements = %q, want %q", got, want)
+	}
+	if visit.DeletedAt == nil {
+		t.Error("DeletedAt is not set on the removed entity")
+	}
+	if db.Entry(visit) != nil {
+		t.Error("removed entity is still tracked after SaveChanges")
+	}
+}
+
+func TestSoftDeletedRowsAreFiltered(t *testing.T) {
+	db, fake := openFakeDB(t)
+	visits := Set[filteredVisit](db)
+
+	for _, query := range []*Query[filteredVisit]{visits.Query(), visits.IgnoreQueryFilters(), visits.WithDeleted()} {
+		if _, err := query.ToSlice(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"SELECT id, tenant_id, status, deleted_at FROM filteredvisits WHERE deleted_at IS NULL",
+		"SELECT id, tenant_id, status, deleted_at FROM filteredvisits",
+		"SELECT id, tenant_id, status, deleted_at FROM filteredvisits",
+	}
+	if got := fake.Statements(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("statements = %q, want %q", got, want)
+	}
+
+	// A tracked soft-deleted entity is filtered in memory
+	deletedAt := time.Now()
+	deleted := &filteredVisit{ID: 2, DeletedAt: &deletedAt}
+	db.Attach(deleted)
+	if found, err := visits.Query().Find(2); err != nil || found != nil {
+		t.Errorf("Find() = %v, %v, want the soft-deleted entity filtered out", found, err)
+	}
+	if found, err := visits.IgnoreQueryFilters().Find(2); err != nil || found != deleted {
+		t.Errorf("IgnoreQueryFilters().Find() = %v, %v, want the tracked entity", found, err)
+	}
+	if n := len(fake.Statements()); n != len(want) {
+		t.Errorf("Find of a tracked entity ran %d statements", n-len(want))
+	}
+}
@@ -1,6 +1,10 @@
 package apolon
 
-import "github.com/jkeresman01/apolon/apolon-shared"
+import (
+	"context"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
 
 // DbSet provides a typed entry point for querying entities
 type DbSet[T any] struct {
@@ -27,6 +31,21 @@ func (s *DbSet[T]) OrderBy(o shared.OrderBy) *Query[T] {
 	return newQuery[T](s.db).OrderBy(o)
 }
 
+// WithContext creates a new query that runs with the given context
+func (s *DbSet[T]) WithContext(ctx context.Context) *Query[T] {
+	return newQuery[T](s.db).WithContext(ctx)
+}
+
+// WithDeleted creates a new query that includes soft-deleted entities
+func (s *DbSet[T]) WithDeleted() *Query[T] {
+	return newQuery[T](s.db).WithDeleted()
+}
+
+// IgnoreQueryFilters creates a new query that bypasses global query filters
+func (s *DbSet[T]) IgnoreQueryFilters() *Query[T] {
+	return newQuery[T](s.db).IgnoreQueryFilters()
+}
+
 // ToSlice returns all entities of this type
 func (s *DbSet[T]) ToSlice() ([]T, error) {
 	return newQuery[T](s.db).ToSlice()
@@ -8,79 +8,183 @@ import (
 
 // EntityEntry tracks a single entity and its state
 type EntityEntry struct {
-	Entity         any
-	State          shared.EntityState
+	Entity     any
+	State      shared.EntityState
+	entityType reflect.Type
+
+	// OriginalValues holds the snapshot values of the mapped fields keyed
+	// by field name. Change detection compares against the snapshot, so
+	// edits to this map have no effect.
 	OriginalValues map[string]any
-	entityType     reflect.Type
-	pkField        string
-	pkValue        any
+
+	model      *shared.Model          // mapping metadata of entityType
+	modelErr   error                  // tag errors of entityType, reported by SaveChanges
+	snapshot   any                    // pointer to a copy of the original values
+	detector   *shared.ChangeDetector // generated change detection, nil for reflection
+	modified   map[string]bool        // explicit per-property IsModified overrides
+	tracker    *ChangeTracker         // owning tracker, nil for temporary entries
+	ref        any                    // key of the entry in the tracker's entries map
+	principals []foreignKeyLink       // entities whose keys this entity references
+	key        entryKey               // key under which the tracker indexes this entry
+	keyed      bool                   // whether key is present in the tracker's key index
+	seq        uint64                 // tracking order, breaks ties between equal keys
 }
 
-// newEntityEntry creates a new entity entry
-func newEntityEntry(entity any, state shared.EntityState) *EntityEntry {
+// newEntityEntry creates a new entity entry, resolving its metadata from models
+func newEntityEntry(entity any, state shared.EntityState, models *shared.ModelRegistry) *EntityEntry {
 	entry := &EntityEntry{
 		Entity:         entity,
 		State:          state,
 		OriginalValues: make(map[string]any),
 	}
-	entry.captureMetadata()
+	entry.captureMetadata(models)
 	if state == shared.Unchanged || state == shared.Modified {
 		entry.captureOriginalValues()
 	}
+	if state == shared.Modified {
+		entry.markAllModified()
+	}
 	return entry
 }
 
 // captureMetadata extracts type and primary key information
-func (e *EntityEntry) captureMetadata() {
+func (e *EntityEntry) captureMetadata(models *shared.ModelRegistry) {
 	v := reflect.ValueOf(e.Entity)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
 	e.entityType = v.Type()
 
-	// Find primary key field (marked with ,pk in apolon tag)
-	for i := 0; i < e.entityType.NumField(); i++ {
-		field := e.entityType.Field(i)
-		tag := field.Tag.Get("apolon")
-		if containsPK(tag) {
-			e.pkField = field.Name
-			e.pkValue = v.Field(i).Interface()
-			break
-		}
-	}
+	e.model, e.modelErr = models.Model(e.entityType)
 
-	// If no pk tag found, default to "ID" field
-	if e.pkField == "" {
-		if idField, ok := e.entityType.FieldByName("ID"); ok {
-			e.pkField = idField.Name
-			e.pkValue = v.FieldByName("ID").Interface()
-		}
+	if reflect.TypeOf(e.Entity).Kind() == reflect.Ptr {
+		e.detector = shared.LookupChangeDetector(e.entityType)
 	}
 }
 
 // captureOriginalValues stores a snapshot of all field values
 func (e *EntityEntry) captureOriginalValues() {
-	v := reflect.ValueOf(e.Entity)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+	if e.detector != nil {
+		e.snapshot = e.detector.Snapshot(e.Entity)
+	} else {
+		v := reflect.ValueOf(e.Entity)
+		if v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+
+		snapshot := reflect.New(e.entityType)
+		snapshot.Elem().Set(v)
+		e.snapshot = snapshot.Interface()
 	}
 
-	for i := 0; i < v.NumField(); i++ {
-		fieldName := e.entityType.Field(i).Name
-		e.OriginalValues[fieldName] = v.Field(i).Interface()
+	original := reflect.ValueOf(e.snapshot).Elem()
+	e.OriginalValues = make(map[string]any, len(e.model.Fields))
+	for _, field := range e.model.Fields {
+		e.OriginalValues[field.Name] = original.FieldByIndex(field.Index).Interface()
 	}
 }
 
-// GetPrimaryKey returns the primary key value
+// GetPrimaryKey returns the primary key value. Composite keys are
+// returned as a []any in key order; entities without a key return nil.
 func (e *EntityEntry) GetPrimaryKey() any {
-	v := reflect.ValueOf(e.Entity)
-	if v.Kind() == reflect.Ptr {
-		v = v.Elem()
+	values := e.KeyValues()
+	switch len(values) {
+	case 0:
+		return nil
+	case 1:
+		return values[0]
+	default:
+		return values
+	}
+}
+
+// KeyValues returns the values of the key fields in key order
+func (e *EntityEntry) KeyValues() []any {
+	return keyValues(e.model, e.fieldValue())
+}
+
+// currentKey returns the tracker key for the entity's current primary key.
+// Entities without a key or with a zero key component are only tracked by pointer.
+func (e *EntityEntry) currentKey() (entryKey, bool) {
+	pk, ok := comparableKey(e.KeyValues())
+	if !ok {
+		return entryKey{}, false
+	}
+	return entryKey{typ: e.entityType, pk: pk}, true
+}
+
+// rejected reports whether the entity could not be tracked, e.g. because
+// it was not passed by pointer; modelErr holds the reason
+func (e *EntityEntry) rejected() bool {
+	return e.ref == e
+}
+
+// keyValues returns the key field values of an entity struct value
+func keyValues(model *shared.Model, v reflect.Value) []any {
+	values := make([]any, len(model.Key))
+	for i, field := range model.Key {
+		values[i] = v.FieldByIndex(field.Index).Interface()
+	}
+	return values
+}
+
+// comparableKey converts key values into a value usable as a map key: the
+// value itself for single keys, an [n]any array for composite keys.
+// Byte slices are converted to strings. It reports false if there is no
+// key, any component is zero or a component cannot be hashed.
+func comparableKey(values []any) (any, bool) {
+	if len(values) == 0 {
+		return nil, false
+	}
+	hashable := make([]any, len(values))
+	for i, value := range values {
+		if value == nil || isZeroValue(value) {
+			return nil, false
+		}
+		var ok bool
+		if hashable[i], ok = hashableValue(value); !ok {
+			return nil, false
+		}
+	}
+	if len(hashable) == 1 {
+		return hashable[0], true
+	}
+
+	key := reflect.New(reflect.ArrayOf(len(hashable), reflect.TypeOf((*any)(nil)).Elem())).Elem()
+	for i, value := range hashable {
+		key.Index(i).Set(reflect.ValueOf(value))
 	}
-	if e.pkField != "" {
-		return v.FieldByName(e.pkField).Interface()
+	return key.Interface(), true
+}
+
+// hashableValue returns a key component in a form usable in a map key
+func hashableValue(value any) (any, bool) {
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		return string(v.Bytes()), true
 	}
-	return nil
+	if !isHashable(v.Type()) {
+		return nil, false
+	}
+	return value, true
+}
+
+// isHashable reports whether values of t can be used as map keys without
+// panicking; interfaces inside t could hold anything, so they are not
+func isHashable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+		return false
+	case reflect.Array:
+		return isHashable(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !isHashable(t.Field(i).Type) {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 // GetChangedProperties returns a map of properties that have changed
@@ -89,19 +193,44 @@ func (e *EntityEntry) GetChangedProperties() map[string]any {
 		return nil
 	}
 
+	changed := e.detectChangedProperties()
+	for name, modified := range e.modified {
+		if !modified {
+			delete(changed, name)
+		} else if _, ok := changed[name]; !ok {
+			changed[name] = e.Property(name).CurrentValue()
+		}
+	}
+
+	return changed
+}
+
+// detectChangedProperties compares current values with the snapshot
+func (e *EntityEntry) detectChangedProperties() map[string]any {
+	if e.snapshot == nil {
+		return map[string]any{}
+	}
+
 	changed := make(map[string]any)
 	v := reflect.ValueOf(e.Entity)
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
 
-	for i := 0; i < v.NumField(); i++ {
-		fieldName := e.entityType.Field(i).Name
-		currentValue := v.Field(i).Interface()
-		originalValue, exists := e.OriginalValues[fieldName]
+	if e.detector != nil {
+		for _, fieldName := range e.detector.Changes(e.Entity, e.snapshot) {
+			if field := e.model.FieldByName(fieldName); field != nil {
+				changed[fieldName] = v.FieldByIndex(field.Index).Interface()
+			}
+		}
+		return changed
+	}
 
-		if exists && !reflect.DeepEqual(currentValue, originalValue) {
-			changed[fieldName] = currentValue
+	original := reflect.ValueOf(e.snapshot).Elem()
+	for _, field := range e.model.Fields {
+		currentValue := v.FieldByIndex(field.Index).Interface()
+		if !reflect.DeepEqual(currentValue, original.FieldByIndex(field.Index).Interface()) {
+			changed[field.Name] = currentValue
 		}
 	}
 
@@ -128,33 +257,16 @@ func (e *EntityEntry) DetectChanges() {
 // AcceptChanges marks the entity as unchanged and updates original values
 func (e *EntityEntry) AcceptChanges() {
 	e.State = shared.Unchanged
+	e.modified = nil
 	e.captureOriginalValues()
 }
 
-// containsPK checks if an apolon tag contains the pk option
-func containsPK(tag string) bool {
-	for _, part := range splitTag(tag) {
-		if part == "pk" {
-			return true
-		}
-	}
-	return false
-}
-
-// splitTag splits an apolon tag by comma
-func splitTag(tag string) []string {
-	if tag == "" {
-		return nil
-	}
-	var parts []string
-	start := 0
-	for i := 0; i <= len(tag); i++ {
-		if i == len(tag) || tag[i] == ',' {
-			if start < i {
-				parts = append(parts, tag[start:i])
-			}
-			start = i + 1
+// markAllModified flags every non-key property as modified, so an entity
+// attached as Modified is written in full
+func (e *EntityEntry) markAllModified() {
+	for _, field := range e.model.Fields {
+		if !e.model.IsKey(field) {
+			e.Property(field.Name).SetModified(true)
 		}
 	}
-	return parts
 }
@@ -0,0 +1,135 @@
+package apolon
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// reflectedPatient has no change detector and is compared with reflection
+type reflectedPatient struct {
+	ID        int       `apolon:"id,pk"`
+	Name      string    `apolon:"name"`
+	Email     string    `apolon:"email"`
+	Age       int       `apolon:"age"`
+	Active    bool      `apolon:"active"`
+	Notes     *string   `apolon:"notes"`
+	CreatedAt time.Time `apolon:"created_at"`
+}
+
+// generatedPatient has the same fields and a detector as emitted by
+// apolon generate
+type generatedPatient struct {
+	ID        int       `apolon:"id,pk"`
+	Name      string    `apolon:"name"`
+	Email     string    `apolon:"email"`
+	Age       int       `apolon:"age"`
+	Active    bool      `apolon:"active"`
+	Notes     *string   `apolon:"notes"`
+	CreatedAt time.Time `apolon:"created_at"`
+}
+
+func init() {
+	shared.RegisterChangeDetector(detectGeneratedPatientChanges)
+}
+
+// detectGeneratedPatientChanges returns the fields of a generatedPatient that differ from its snapshot
+func detectGeneratedPatientChanges(current, original *generatedPatient) []string {
+	var changed []string
+	if current.ID != original.ID {
+		changed = append(changed, "ID")
+	}
+	if current.Name != original.Name {
+		changed = append(changed, "Name")
+	}
+	if current.Email != original.Email {
+		changed = append(changed, "Email")
+	}
+	if current.Age != original.Age {
+		changed = append(changed, "Age")
+	}
+	if current.Active != original.Active {
+		changed = append(changed, "Active")
+	}
+	if !shared.Equal(current.Notes, original.Notes) {
+		changed = append(changed, "Notes")
+	}
+	if !shared.Equal(current.CreatedAt, original.CreatedAt) {
+		changed = append(changed, "CreatedAt")
+	}
+	return changed
+}
+
+func TestChangeDetectionMatchesReflection(t *testing.T) {
+	db := openTestDB(t)
+	notes := "first visit"
+
+	reflected := &reflectedPatient{ID: 1, Name: "Ana", Notes: &notes}
+	generated := &generatedPatient{ID: 1, Name: "Ana", Notes: &notes}
+	reflectedEntry := db.Attach(reflected)
+	generatedEntry := db.Attach(generated)
+	if reflectedEntry.detector != nil || generatedEntry.detector == nil {
+		t.Fatal("expected only generatedPatient to use a change detector")
+	}
+
+	other := "follow-up"
+	reflected.Age, reflected.Notes = 30, &other
+	generated.Age, generated.Notes = 30, &other
+
+	want := reflectedEntry.GetChangedProperties()
+	got := generatedEntry.GetChangedProperties()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("generated changes = %v, reflection changes = %v", got, want)
+	}
+	if generatedEntry.OriginalValues["Age"] != 0 || reflectedEntry.OriginalValues["Age"] != 0 {
+		t.Errorf("OriginalValues do not hold the snapshot")
+	}
+}
+
+// benchmarkDetectChanges attaches rows entities, modifies every tenth and
+// measures a DetectChanges pass over all of them
+func benchmarkDetectChanges[T any](b *testing.B, rows int, newRow func(i int) *T, modify func(*T)) {
+	db, err := Open("postgres://localhost/apolon_bench?sslmode=disable")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	entities := make([]*T, rows)
+	for i := range entities {
+		entities[i] = newRow(i + 1)
+		db.Attach(entities[i])
+	}
+	for i := 0; i < rows; i += 10 {
+		modify(entities[i])
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.ChangeTracker.DetectChanges()
+	}
+}
+
+func BenchmarkDetectChanges(b *testing.B) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, rows := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("reflection/%d", rows), func(b *testing.B) {
+			benchmarkDetectChanges(b, rows,
+				func(i int) *reflectedPatient {
+					return &reflectedPatient{ID: i, Name: "Ana", Email: "ana@example.com", Age: 30, CreatedAt: created}
+				},
+				func(p *reflectedPatient) { p.Age++ })
+		})
+		b.Run(fmt.Sprintf("generated/%d", rows), func(b *testing.B) {
+			benchmarkDetectChanges(b, rows,
+				func(i int) *generatedPatient {
+					return &generatedPatient{ID: i, Name: "Ana", Email: "ana@example.com", Age: 30, CreatedAt: created}
+				},
+				func(p *generatedPatient) { p.Age++ })
+		})
+	}
+}
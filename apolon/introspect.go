@@ -0,0 +1,192 @@
+package apolon
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	shared "github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// introspectColumnsSQL lists the columns of a table in ordinal order
+const introspectColumnsSQL = `
+SELECT a.attname,
+       format_type(a.atttypid, a.atttypmod),
+       a.attnotnull,
+       pg_get_expr(d.adbin, d.adrelid),
+       col_description(a.attrelid, a.attnum)
+FROM pg_attribute a
+LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+WHERE a.attrelid = to_regclass($1) AND a.attnum > 0 AND NOT a.attisdropped
+ORDER BY a.attnum`
+
+// introspectConstraintsSQL lists the constraints of a table with their
+// columns in key order
+const introspectConstraintsSQL = `
+SELECT con.conname,
+       con.contype,
+       pg_get_constraintdef(con.oid),
+       (SELECT string_agg(a.attname, ',' ORDER BY k.ord)
+        FROM unnest(con.conkey) WITH ORDINALITY AS k(attnum, ord)
+        JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum)
+FROM pg_constraint con
+WHERE con.conrelid = to_regclass($1)
+ORDER BY con.conname`
+
+// introspectIndexesSQL lists the indexes of a table that do not back a
+// constraint with their access method, predicate and key columns, one per
+// line with their ordering
+const introspectIndexesSQL = `
+SELECT c.relname,
+       i.indisunique,
+       am.amname,
+       pg_get_expr(i.indpred, i.indrelid, true),
+       (SELECT string_agg(pg_get_indexdef(i.indexrelid, k, true)
+                || CASE WHEN i.indoption[k - 1] & 1 = 1 THEN ' DESC' ELSE '' END
+                || CASE WHEN i.indoption[k - 1] & 3 = 1 THEN ' NULLS LAST'
+                        WHEN i.indoption[k - 1] & 3 = 2 THEN ' NULLS FIRST'
+                        ELSE '' END,
+                E'\n' ORDER BY k)
+        FROM generate_series(1, i.indnkeyatts) AS k)
+FROM pg_index i
+JOIN pg_class c ON c.oid = i.indexrelid
+JOIN pg_am am ON am.oid = c.relam
+WHERE i.indrelid = to_regclass($1)
+  AND NOT EXISTS (SELECT 1 FROM pg_constraint con WHERE con.conindid = i.indexrelid)
+ORDER BY c.relname`
+
+// introspectTable reads the current definition of a table from pg_catalog.
+// It returns nil if the table does not exist. Column types are reported as
+// by format_type, e.g. "character varying(100)".
+func (db *DB) introspectTable(ctx context.Context, q querier, table string) (*shared.SchemaInfo, error) {
+	var exists bool
+	if err := q.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("introspect %s failed: %w", table, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	schemaName, _ := shared.SplitTableName(table)
+	schema := &shared.SchemaInfo{Table: table, Schema: schemaName}
+
+	if err := db.introspectColumns(ctx, q, schema); err != nil {
+		return nil, fmt.Errorf("introspect %s failed: %w", table, err)
+	}
+	if err := db.introspectConstraints(ctx, q, schema); err != nil {
+		return nil, fmt.Errorf("introspect %s failed: %w", table, err)
+	}
+	if err := db.introspectIndexes(ctx, q, schema); err != nil {
+		return nil, fmt.Errorf("introspect %s failed: %w", table, err)
+	}
+	return schema, nil
+}
+
+// introspectColumns adds the table's columns to schema
+func (db *DB) introspectColumns(ctx context.Context, q querier, schema *shared.SchemaInfo) error {
+	rows, err := q.QueryContext(ctx, introspectColumnsSQL, schema.Table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var col shared.ColumnInfo
+		var defaultValue, comment sql.NullString
+		if err := rows.Scan(&col.Name, &col.SQLType, &col.IsNotNull, &defaultValue, &comment); err != nil {
+			return err
+		}
+		if defaultValue.Valid {
+			col.DefaultValue = &defaultValue.String
+		}
+		col.Comment = comment.String
+		schema.Columns = append(schema.Columns, col)
+	}
+	return rows.Err()
+}
+
+// introspectConstraints adds the table's primary key, single-column unique
+// constraints, foreign keys and checks to schema
+func (db *DB) introspectConstraints(ctx context.Context, q querier, schema *shared.SchemaInfo) error {
+	rows, err := q.QueryContext(ctx, introspectConstraintsSQL, schema.Table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, kind, definition string
+		var columnList sql.NullString
+		if err := rows.Scan(&name, &kind, &definition, &columnList); err != nil {
+			return err
+		}
+
+		var columns []string
+		if columnList.Valid {
+			columns = strings.Split(columnList.String, ",")
+		}
+
+		switch kind {
+		case "p":
+			schema.PrimaryKey = columns
+			for _, column := range columns {
+				if col := findColumn(schema, column); col != nil {
+					col.IsPrimaryKey = true
+				}
+			}
+		case "u":
+			if len(columns) == 1 {
+				if col := findColumn(schema, columns[0]); col != nil {
+					col.IsUnique = true
+				}
+			}
+		case "f":
+			schema.ForeignKeys = append(schema.ForeignKeys, shared.ForeignKeyInfo{Name: name, Columns: columns})
+		case "c":
+			schema.Checks = append(schema.Checks, shared.CheckInfo{Name: name, Expression: checkExpression(definition)})
+		}
+	}
+	return rows.Err()
+}
+
+// checkExpression returns the expression of a check constraint definition
+// as reported by pg_get_constraintdef, e.g. "CHECK ((age >= 0)) NOT VALID"
+func checkExpression(definition string) string {
+	definition = strings.TrimSuffix(definition, " NOT VALID")
+	definition = strings.TrimSuffix(definition, " NO INHERIT")
+	return strings.TrimPrefix(definition, "CHECK ")
+}
+
+// introspectIndexes adds the table's indexes to schema
+func (db *DB) introspectIndexes(ctx context.Context, q querier, schema *shared.SchemaInfo) error {
+	rows, err := q.QueryContext(ctx, introspectIndexesSQL, schema.Table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var index shared.IndexInfo
+		var where, columns sql.NullString
+		if err := rows.Scan(&index.Name, &index.Unique, &index.Method, &where, &columns); err != nil {
+			return err
+		}
+		index.Where = where.String
+		if columns.Valid {
+			index.Columns = strings.Split(columns.String, "\n")
+		}
+		schema.Indexes = append(schema.Indexes, index)
+	}
+	return rows.Err()
+}
+
+// findColumn returns the named column of schema, or nil
+func findColumn(schema *shared.SchemaInfo, name string) *shared.ColumnInfo {
+	for i := range schema.Columns {
+		if schema.Columns[i].Name == name {
+			return &schema.Columns[i]
+		}
+	}
+	return nil
+}
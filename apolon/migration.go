@@ -1,6 +1,7 @@
 package apolon
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -17,6 +18,12 @@ func newMigrationBuilder(db *DB) *MigrationBuilder {
 	return &MigrationBuilder{db: db}
 }
 
+// NewMigrationBuilder creates a MigrationBuilder for rendering DDL without
+// a database connection
+func NewMigrationBuilder() *MigrationBuilder {
+	return newMigrationBuilder(nil)
+}
+
 // BuildCreateTableSQL generates a CREATE TABLE IF NOT EXISTS statement
 func (mb *MigrationBuilder) BuildCreateTableSQL(schema *shared.SchemaInfo) string {
 	var sb strings.Builder
@@ -25,10 +32,26 @@ func (mb *MigrationBuilder) BuildCreateTableSQL(schema *shared.SchemaInfo) strin
 	sb.WriteString(schema.Table)
 	sb.WriteString(" (\n")
 
-	columnDefs := make([]string, 0, len(schema.Columns))
+	// Keys spanning several columns are declared as a table constraint
+	compositeKey := len(schema.PrimaryKey) > 1
+
+	checks := schema.CheckConstraints()
+	columnDefs := make([]string, 0, len(schema.Columns)+len(schema.ForeignKeys)+len(checks)+1)
 	for _, col := range schema.Columns {
+		if compositeKey {
+			col.IsPrimaryKey = false
+		}
 		columnDefs = append(columnDefs, "    "+mb.buildColumnDefinition(col))
 	}
+	if compositeKey {
+		columnDefs = append(columnDefs, "    PRIMARY KEY ("+strings.Join(schema.PrimaryKey, ", ")+")")
+	}
+	for _, fk := range schema.ForeignKeys {
+		columnDefs = append(columnDefs, "    "+mb.buildForeignKeyDefinition(fk))
+	}
+	for _, check := range checks {
+		columnDefs = append(columnDefs, "    "+mb.buildCheckDefinition(check))
+	}
 
 	sb.WriteString(strings.Join(columnDefs, ",\n"))
 	sb.WriteString("\n)")
@@ -36,6 +59,94 @@ func (mb *MigrationBuilder) BuildCreateTableSQL(schema *shared.SchemaInfo) strin
 	return sb.String()
 }
 
+// BuildCreateIndexSQL generates a CREATE INDEX IF NOT EXISTS statement.
+// The index is created in the table's schema.
+func (mb *MigrationBuilder) BuildCreateIndexSQL(table string, index shared.IndexInfo) string {
+	var sb strings.Builder
+
+	sb.WriteString("CREATE ")
+	if index.Unique {
+		sb.WriteString("UNIQUE ")
+	}
+	sb.WriteString("INDEX ")
+	if index.Concurrently {
+		sb.WriteString("CONCURRENTLY ")
+	}
+	sb.WriteString("IF NOT EXISTS ")
+	sb.WriteString(index.Name)
+	sb.WriteString(" ON ")
+	sb.WriteString(table)
+	if index.Method != "" {
+		sb.WriteString(" USING ")
+		sb.WriteString(index.Method)
+	}
+	sb.WriteString(" (")
+	sb.WriteString(strings.Join(index.Columns, ", "))
+	sb.WriteString(")")
+	if index.Where != "" {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(index.Where)
+	}
+
+	return sb.String()
+}
+
+// BuildAddForeignKeySQL generates an ALTER TABLE statement adding a foreign
+// key, wrapped in a DO block that skips constraints which already exist
+func (mb *MigrationBuilder) BuildAddForeignKeySQL(table string, fk shared.ForeignKeyInfo) string {
+	return fmt.Sprintf(
+		"DO $$\nBEGIN\n    ALTER TABLE %s ADD %s;\nEXCEPTION WHEN duplicate_object THEN NULL;\nEND\n$$",
+		table,
+		mb.buildForeignKeyDefinition(fk),
+	)
+}
+
+// BuildCommentSQL generates a COMMENT ON COLUMN statement. An empty
+// comment removes the existing one.
+func (mb *MigrationBuilder) BuildCommentSQL(table string, col shared.ColumnInfo) string {
+	comment := "NULL"
+	if col.Comment != "" {
+		comment = quoteLiteral(col.Comment)
+	}
+	return fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s", table, col.Name, comment)
+}
+
+// BuildPlanSQL renders migration steps as a script, each statement preceded
+// by its description and whether it is safe or destructive
+func (mb *MigrationBuilder) BuildPlanSQL(steps []MigrationStep) string {
+	var sb strings.Builder
+	for i, step := range steps {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		safety := "safe"
+		if step.Skipped {
+			safety = "skipped, destructive"
+		} else if step.Destructive {
+			safety = "destructive"
+		}
+		if step.NonTransactional {
+			safety += ", outside a transaction"
+		}
+		fmt.Fprintf(&sb, "-- %s: %s\n", safety, step.Description)
+		if len(step.Args) > 0 {
+			sb.WriteString("-- with " + formatArgs(step.Args, true) + "\n")
+		}
+		if step.Skipped {
+			// Commented out, so the script runs what AutoMigrate would
+			sb.WriteString("-- " + strings.ReplaceAll(step.SQL, "\n", "\n-- ") + ";\n")
+			continue
+		}
+		sb.WriteString(step.SQL + ";\n")
+	}
+	return sb.String()
+}
+
+// BuildCreateSchemaSQL generates a CREATE SCHEMA IF NOT EXISTS statement
+func (mb *MigrationBuilder) BuildCreateSchemaSQL(schema string) string {
+	return "CREATE SCHEMA IF NOT EXISTS " + schema
+}
+
 // buildColumnDefinition generates the SQL for a single column definition
 func (mb *MigrationBuilder) buildColumnDefinition(col shared.ColumnInfo) string {
 	var parts []string
@@ -69,19 +180,421 @@ func (mb *MigrationBuilder) buildColumnDefinition(col shared.ColumnInfo) string
 	return strings.Join(parts, " ")
 }
 
-// AutoMigrate creates tables for the given entities if they don't exist
+// buildForeignKeyDefinition generates the SQL for a foreign key constraint
+func (mb *MigrationBuilder) buildForeignKeyDefinition(fk shared.ForeignKeyInfo) string {
+	def := fmt.Sprintf(
+		"CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+		fk.Name,
+		strings.Join(fk.Columns, ", "),
+		fk.RefTable,
+		strings.Join(fk.RefColumns, ", "),
+	)
+	if fk.OnDelete != "" {
+		def += " ON DELETE " + fk.OnDelete
+	}
+	return def
+}
+
+// buildCheckDefinition generates the SQL for a CHECK constraint
+func (mb *MigrationBuilder) buildCheckDefinition(check shared.CheckInfo) string {
+	return fmt.Sprintf("CONSTRAINT %s CHECK (%s)", check.Name, check.Expression)
+}
+
+// quoteLiteral quotes s as an SQL string literal
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// AutoMigrate brings the tables of the given entities in line with their
+// models, skipping destructive steps; see AutoMigrateWith
 func (db *DB) AutoMigrate(entities ...any) error {
-	mb := newMigrationBuilder(db)
+	return db.AutoMigrateWith(MigrateOptions{}, entities...)
+}
 
-	for _, entity := range entities {
-		schema := shared.ParseSchema(entity)
-		sql := mb.BuildCreateTableSQL(schema)
+// AutoMigrateWith creates missing tables and alters existing ones to match
+// the models of the given entities. The current definition of each table is
+// read from pg_catalog and diffed against its model: missing columns,
+// constraints and indexes are added, checks and indexes whose definition
+// changed are recreated, and column types, nullability, defaults and
+// comments are changed. Dropping columns and changing types are
+// destructive, as are setting NOT NULL and adding checks, which fail on
+// existing rows that violate them; these are only applied if
+// opts.AllowDestructive is set.
+//
+// Tables are created after the tables they reference; foreign keys closing
+// a cycle are added once all tables exist. Rows configured with HasData
+// are then upserted if they are missing or differ. The migration holds an advisory
+// lock, so processes starting at once migrate one after another, and runs
+// in a single transaction. Steps that cannot run in a transaction, such as
+// CREATE INDEX CONCURRENTLY, run one by one after it commits.
+func (db *DB) AutoMigrateWith(opts MigrateOptions, entities ...any) error {
+	ctx := context.Background()
 
-		_, err := db.conn.Exec(sql)
-		if err != nil {
-			return fmt.Errorf("failed to create table %s: %w", schema.Table, err)
+	conn, unlock, err := db.lockMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	steps, err := db.migrationSteps(ctx, conn, entities)
+	if err != nil {
+		return err
+	}
+
+	transactional, nonTransactional := partitionSteps(steps, opts.AllowDestructive)
+	if err := db.execStepsInTx(ctx, conn, transactional); err != nil {
+		return err
+	}
+	for _, step := range nonTransactional {
+		if _, err := conn.ExecContext(ctx, step.SQL, step.Args...); err != nil {
+			return fmt.Errorf("failed to %s: %w", step.Description, err)
 		}
 	}
 
 	return nil
 }
+
+// MigrationPlan returns the steps AutoMigrate would run for the given
+// entities; see MigrationPlanWith
+func (db *DB) MigrationPlan(entities ...any) ([]MigrationStep, error) {
+	return db.MigrationPlanWith(MigrateOptions{}, entities...)
+}
+
+// MigrationPlanWith returns the steps AutoMigrateWith would run with opts
+// for the given entities, in order, without changing the database.
+// Destructive steps that opts do not allow are listed with Skipped set.
+// Use MigrationBuilder.BuildPlanSQL to render the plan as a script.
+func (db *DB) MigrationPlanWith(opts MigrateOptions, entities ...any) ([]MigrationStep, error) {
+	steps, err := db.migrationSteps(context.Background(), db.conn, entities)
+	if err != nil {
+		return nil, err
+	}
+	return planSteps(steps, opts), nil
+}
+
+// SchemaMigrationPlan returns the steps migrating the database to the given
+// table definitions, like MigrationPlanWith for models parsed from source
+func (db *DB) SchemaMigrationPlan(opts MigrateOptions, schemas []*shared.SchemaInfo) ([]MigrationStep, error) {
+	copies := make([]*shared.SchemaInfo, len(schemas))
+	for i, schema := range schemas {
+		copied := *schema
+		copies[i] = &copied
+	}
+
+	current, err := db.introspectSchemas(context.Background(), db.conn, copies)
+	if err != nil {
+		return nil, err
+	}
+	steps := newMigrationBuilder(db).diffSchemas(current, copies, false)
+	return planSteps(steps, opts), nil
+}
+
+// planSteps orders steps as AutoMigrateWith runs them with opts, marking
+// the destructive steps it skips
+func planSteps(steps []MigrationStep, opts MigrateOptions) []MigrationStep {
+	var transactional, nonTransactional []MigrationStep
+	for _, step := range steps {
+		step.Skipped = step.Destructive && !opts.AllowDestructive
+		if step.NonTransactional {
+			nonTransactional = append(nonTransactional, step)
+		} else {
+			transactional = append(transactional, step)
+		}
+	}
+	return append(transactional, nonTransactional...)
+}
+
+// partitionSteps separates the steps to run in the migration transaction
+// from those to run after it, dropping destructive steps unless allowed
+func partitionSteps(steps []MigrationStep, allowDestructive bool) (transactional, nonTransactional []MigrationStep) {
+	for _, step := range steps {
+		switch {
+		case step.Destructive && !allowDestructive:
+		case step.NonTransactional:
+			nonTransactional = append(nonTransactional, step)
+		default:
+			transactional = append(transactional, step)
+		}
+	}
+	return transactional, nonTransactional
+}
+
+// execStepsInTx runs steps in a single transaction
+func (db *DB) execStepsInTx(ctx context.Context, q querier, steps []MigrationStep) error {
+	if len(steps) == 0 {
+		return nil
+	}
+
+	tx, err := q.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step.SQL, step.Args...); err != nil {
+			return fmt.Errorf("failed to %s: %w", step.Description, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration: %w", err)
+	}
+	return nil
+}
+
+// migrationSteps diffs the models of the given entities and their seed
+// data against the database and returns the steps that bring the database
+// up to date
+func (db *DB) migrationSteps(ctx context.Context, q querier, entities []any) ([]MigrationStep, error) {
+	models, schemas, err := db.migrationSchemas(entities)
+	if err != nil {
+		return nil, err
+	}
+	current, err := db.introspectSchemas(ctx, q, schemas)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := newMigrationBuilder(db).diffSchemas(current, schemas, false)
+	seeds, err := db.seedSteps(ctx, q, models, schemas, current)
+	if err != nil {
+		return nil, err
+	}
+	return append(steps, seeds...), nil
+}
+
+// introspectSchemas reads the current definitions of the tables that exist
+func (db *DB) introspectSchemas(ctx context.Context, q querier, schemas []*shared.SchemaInfo) (map[string]*shared.SchemaInfo, error) {
+	current := make(map[string]*shared.SchemaInfo, len(schemas))
+	for _, schema := range schemas {
+		existing, err := db.introspectTable(ctx, q, schema.Table)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			current[schema.Table] = existing
+		}
+	}
+
+	return current, nil
+}
+
+// DiffSchemas returns the steps migrating a database from one set of table
+// definitions to another, e.g. from a model snapshot to the current models.
+// Unlike AutoMigrate, tables, constraints and indexes missing from to are
+// dropped.
+func DiffSchemas(from, to []*shared.SchemaInfo) []MigrationStep {
+	mb := newMigrationBuilder(nil)
+
+	current := make(map[string]*shared.SchemaInfo, len(from))
+	for _, schema := range from {
+		current[schema.Table] = schema
+	}
+	targets := make(map[string]bool, len(to))
+	copies := make([]*shared.SchemaInfo, len(to))
+	for i, schema := range to {
+		targets[schema.Table] = true
+		copied := *schema
+		copies[i] = &copied
+	}
+	steps := mb.diffSchemas(current, copies, true)
+
+	// Drop removed tables after their dependents
+	var removed []*shared.SchemaInfo
+	for _, schema := range from {
+		if !targets[schema.Table] {
+			copied := *schema
+			removed = append(removed, &copied)
+		}
+	}
+	ordered, _ := orderByReferences(removed)
+	for i := len(ordered) - 1; i >= 0; i-- {
+		steps = append(steps, MigrationStep{
+			SQL:         "DROP TABLE IF EXISTS " + ordered[i].Table,
+			Description: "drop table " + ordered[i].Table,
+			Destructive: true,
+		})
+	}
+
+	return steps
+}
+
+// diffSchemas returns the steps creating or altering the target tables,
+// given the current definitions of those that exist. Tables are created
+// after the tables they reference; foreign keys closing a cycle are added
+// once all tables exist, and with prune set, foreign keys no longer
+// declared are dropped last. The targets' foreign keys are reordered in place.
+func (mb *MigrationBuilder) diffSchemas(current map[string]*shared.SchemaInfo, targets []*shared.SchemaInfo, prune bool) []MigrationStep {
+	declared := make(map[string][]shared.ForeignKeyInfo, len(targets))
+	for _, schema := range targets {
+		declared[schema.Table] = schema.ForeignKeys
+	}
+	ordered, deferred := orderByReferences(targets)
+
+	var steps []MigrationStep
+	for _, schema := range ordered {
+		if existing := current[schema.Table]; existing != nil {
+			steps = append(steps, mb.diffTableSteps(existing, schema, prune)...)
+		} else {
+			steps = append(steps, mb.createTableSteps(schema)...)
+		}
+	}
+
+	for _, ref := range deferred {
+		if existing := current[ref.table]; existing != nil && hasConstraint(existing.ForeignKeys, ref.fk.Name) {
+			continue
+		}
+		steps = append(steps, MigrationStep{
+			SQL:         mb.BuildAddForeignKeySQL(ref.table, ref.fk),
+			Description: fmt.Sprintf("add foreign key %s on %s", ref.fk.Name, ref.table),
+		})
+	}
+
+	if prune {
+		for _, schema := range ordered {
+			existing := current[schema.Table]
+			if existing == nil {
+				continue
+			}
+			for _, fk := range existing.ForeignKeys {
+				if !hasConstraint(declared[schema.Table], fk.Name) {
+					steps = append(steps, mb.dropConstraintStep(schema.Table, fk.Name))
+				}
+			}
+		}
+	}
+
+	return steps
+}
+
+// migrationSchemas returns the models of the given entities and their
+// schemas, including foreign keys inferred from navigations between them
+func (db *DB) migrationSchemas(entities []any) ([]*shared.Model, []*shared.SchemaInfo, error) {
+	models := make([]*shared.Model, 0, len(entities))
+	schemas := make(map[*shared.Model]*shared.SchemaInfo, len(entities))
+	for _, entity := range entities {
+		model, err := db.models.ModelOf(entity)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := schemas[model]; ok {
+			continue
+		}
+		schema := model.SchemaInfo()
+		schema.ForeignKeys = append([]shared.ForeignKeyInfo(nil), schema.ForeignKeys...)
+		models = append(models, model)
+		schemas[model] = schema
+	}
+
+	for _, model := range models {
+		for _, nav := range model.Navigations {
+			target, err := db.models.Model(nav.Target)
+			if err != nil {
+				return nil, nil, err
+			}
+			if _, ok := schemas[target]; !ok {
+				continue
+			}
+
+			dependent, principal, field := navigationForeignKey(model, target, nav.Name)
+			if field == nil || len(principal.Key) != 1 {
+				continue
+			}
+			schema := schemas[dependent]
+			if hasForeignKey(schema, field.Column.Name) {
+				continue
+			}
+			schema.ForeignKeys = append(schema.ForeignKeys, shared.ForeignKeyInfo{
+				Name:       shared.ForeignKeyName(dependent.Table, field.Column.Name),
+				Columns:    []string{field.Column.Name},
+				RefTable:   principal.Table,
+				RefColumns: []string{principal.Key[0].Column.Name},
+				OnDelete:   nav.OnDelete,
+			})
+		}
+	}
+
+	ordered := make([]*shared.SchemaInfo, len(models))
+	for i, model := range models {
+		ordered[i] = schemas[model]
+	}
+	return models, ordered, nil
+}
+
+// navigationForeignKey resolves the foreign key behind a navigation from
+// parent to child, following the conventions of TrackGraph. It returns a
+// nil field if neither side has a matching <Name>ID field.
+func navigationForeignKey(parent, child *shared.Model, navigation string) (dependent, principal *shared.Model, field *shared.Field) {
+	if name := foreignKeyField(child, parent.Type.Name()+"ID"); name != "" {
+		return child, parent, child.FieldByName(name)
+	}
+	if name := foreignKeyField(parent, navigation+"ID", child.Type.Name()+"ID"); name != "" {
+		return parent, child, parent.FieldByName(name)
+	}
+	return nil, nil, nil
+}
+
+// hasForeignKey reports whether a foreign key already covers the column
+func hasForeignKey(schema *shared.SchemaInfo, column string) bool {
+	for _, fk := range schema.ForeignKeys {
+		if len(fk.Columns) == 1 && fk.Columns[0] == column {
+			return true
+		}
+	}
+	return false
+}
+
+// deferredForeignKey is a foreign key added after its table is created
+type deferredForeignKey struct {
+	table string
+	fk    shared.ForeignKeyInfo
+}
+
+// orderByReferences sorts schemas so that referenced tables are created
+// first. Foreign keys that would close a cycle are removed from their
+// schema and returned to be added once all tables exist.
+func orderByReferences(schemas []*shared.SchemaInfo) ([]*shared.SchemaInfo, []deferredForeignKey) {
+	byTable := make(map[string]*shared.SchemaInfo, len(schemas))
+	for _, schema := range schemas {
+		byTable[schema.Table] = schema
+	}
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*shared.SchemaInfo]int, len(schemas))
+	ordered := make([]*shared.SchemaInfo, 0, len(schemas))
+	var deferred []deferredForeignKey
+
+	var visit func(schema *shared.SchemaInfo)
+	visit = func(schema *shared.SchemaInfo) {
+		if state[schema] != 0 {
+			return
+		}
+		state[schema] = visiting
+
+		var inline []shared.ForeignKeyInfo
+		for _, fk := range schema.ForeignKeys {
+			ref := byTable[fk.RefTable]
+			if ref != nil && ref != schema && state[ref] == visiting {
+				deferred = append(deferred, deferredForeignKey{table: schema.Table, fk: fk})
+				continue
+			}
+			if ref != nil {
+				visit(ref)
+			}
+			inline = append(inline, fk)
+		}
+		schema.ForeignKeys = inline
+
+		state[schema] = visited
+		ordered = append(ordered, schema)
+	}
+
+	for _, schema := range schemas {
+		visit(schema)
+	}
+	return ordered, deferred
+}
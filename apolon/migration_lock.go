@@ -0,0 +1,81 @@
+package apolon
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
+
+// migrationLockKey identifies the advisory lock held while migrating
+// ("apolon" in ASCII)
+const migrationLockKey int64 = 0x61706f6c6f6e
+
+// defaultMigrationLockTimeout is how long migrations wait for another
+// process to finish migrating
+const defaultMigrationLockTimeout = time.Minute
+
+// migrationLockPollInterval is the delay between attempts to take the lock
+const migrationLockPollInterval = 100 * time.Millisecond
+
+// querier is the subset of *sql.DB and *sql.Conn used to migrate, so that
+// migrations can run on the connection holding the migration lock
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// lockMigrations takes the migration advisory lock on a dedicated
+// connection, so that processes migrating the same database at once run
+// one after another. It gives up after the DB's migration lock timeout.
+// Migrations run on the returned connection, so they need no other
+// connection from the pool; the returned function releases the lock.
+func (db *DB) lockMigrations(ctx context.Context) (*sql.Conn, func(), error) {
+	timeout := db.migrationLockTimeout
+	if timeout <= 0 {
+		timeout = defaultMigrationLockTimeout
+	}
+
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("migration lock failed: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", migrationLockKey).Scan(&locked); err != nil {
+			conn.Close()
+			return nil, nil, fmt.Errorf("migration lock failed: %w", err)
+		}
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			conn.Close()
+			return nil, nil, fmt.Errorf("migration lock failed: another migration is still running after %s", timeout)
+		}
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return nil, nil, fmt.Errorf("migration lock failed: %w", ctx.Err())
+		case <-time.After(migrationLockPollInterval):
+		}
+	}
+
+	return conn, func() {
+		// The lock belongs to the session, so release it before the
+		// connection returns to the pool. If it cannot be released, the
+		// session is closed instead of being handed out still holding it.
+		var unlocked bool
+		err := conn.QueryRowContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey).Scan(&unlocked)
+		if err != nil || !unlocked {
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
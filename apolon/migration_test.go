@@ -0,0 +1,152 @@
+package apolon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+func TestPlanStepsMarksSkippedSteps(t *testing.T) {
+	steps := []MigrationStep{
+		{SQL: "CREATE INDEX CONCURRENTLY idx_patients_name ON patients (name)", Description: "create index", NonTransactional: true},
+		{SQL: "ALTER TABLE patients DROP COLUMN notes", Description: "drop column", Destructive: true},
+		{SQL: "ALTER TABLE patients ADD COLUMN email TEXT", Description: "add column"},
+	}
+
+	plan := planSteps(steps, MigrateOptions{})
+	var got []string
+	for _, step := range plan {
+		got = append(got, step.Description)
+	}
+	if want := "drop column,add column,create index"; strings.Join(got, ",") != want {
+		t.Errorf("plan order = %v, want %s", got, want)
+	}
+	if !plan[0].Skipped || plan[1].Skipped || plan[2].Skipped {
+		t.Errorf("only the destructive step should be skipped: %+v", plan)
+	}
+	if steps[1].Skipped {
+		t.Error("planSteps modified its input")
+	}
+
+	script := NewMigrationBuilder().BuildPlanSQL(plan)
+	if !strings.Contains(script, "-- ALTER TABLE patients DROP COLUMN notes;") {
+		t.Errorf("skipped step is not commented out:\n%s", script)
+	}
+
+	for _, step := range planSteps(steps, MigrateOptions{AllowDestructive: true}) {
+		if step.Skipped {
+			t.Errorf("step %q skipped although destructive steps are allowed", step.Description)
+		}
+	}
+}
+
+func TestOrderByReferences(t *testing.T) {
+	fk := func(column, table string) shared.ForeignKeyInfo {
+		return shared.ForeignKeyInfo{Name: "fk_" + column, Columns: []string{column}, RefTable: table, RefColumns: []string{"id"}}
+	}
+	// departments and employees reference each other, employees also
+	// references itself and a table outside the set
+	departments := &shared.SchemaInfo{Table: "departments", ForeignKeys: []shared.ForeignKeyInfo{fk("manager_id", "employees")}}
+	employees := &shared.SchemaInfo{Table: "employees", ForeignKeys: []shared.ForeignKeyInfo{
+		fk("department_id", "departments"), fk("mentor_id", "employees"), fk("country_id", "countries"),
+	}}
+	offices := &shared.SchemaInfo{Table: "offices"}
+
+	ordered, deferred := orderByReferences([]*shared.SchemaInfo{departments, employees, offices})
+
+	var tables []string
+	for _, schema := range ordered {
+		tables = append(tables, schema.Table)
+	}
+	if want := "employees,departments,offices"; strings.Join(tables, ",") != want {
+		t.Errorf("ordered = %v, want %s", tables, want)
+	}
+	if len(deferred) != 1 || deferred[0].table != "employees" || deferred[0].fk.Name != "fk_department_id" {
+		t.Fatalf("deferred = %+v, want the employees foreign key closing the cycle", deferred)
+	}
+	if len(employees.ForeignKeys) != 2 || len(departments.ForeignKeys) != 1 {
+		t.Errorf("inline foreign keys: employees %v, departments %v", employees.ForeignKeys, departments.ForeignKeys)
+	}
+}
+
+func TestDiffSchemasAddsCyclicForeignKeysLast(t *testing.T) {
+	departments := &shared.SchemaInfo{
+		Table:   "departments",
+		Columns: []shared.ColumnInfo{{Name: "id", SQLType: "SERIAL", IsPrimaryKey: true}, {Name: "manager_id", SQLType: "INTEGER"}},
+		ForeignKeys: []shared.ForeignKeyInfo{
+			{Name: "fk_departments_manager_id", Columns: []string{"manager_id"}, RefTable: "employees", RefColumns: []string{"id"}},
+		},
+	}
+	employees := &shared.SchemaInfo{
+		Table:   "employees",
+		Columns: []shared.ColumnInfo{{Name: "id", SQLType: "SERIAL", IsPrimaryKey: true}, {Name: "department_id", SQLType: "INTEGER"}},
+		ForeignKeys: []shared.ForeignKeyInfo{
+			{Name: "fk_employees_department_id", Columns: []string{"department_id"}, RefTable: "departments", RefColumns: []string{"id"}},
+		},
+	}
+
+	steps := DiffSchemas(nil, []*shared.SchemaInfo{departments, employees})
+	var got []string
+	for _, step := range steps {
+		got = append(got, step.Description)
+	}
+	want := "create table employees,create table departments,add foreign key fk_employees_department_id on employees"
+	if strings.Join(got, ",") != want {
+		t.Errorf("steps = %v, want %s", got, want)
+	}
+	if strings.Contains(steps[0].SQL, "fk_employees_department_id") || !strings.Contains(steps[1].SQL, "fk_departments_manager_id") {
+		t.Errorf("foreign keys closing no cycle should be created inline:\n%s\n%s", steps[0].SQL, steps[1].SQL)
+	}
+}
+
+func TestBuildCreateIndexSQL(t *testing.T) {
+	mb := NewMigrationBuilder()
+	tests := []struct {
+		index shared.IndexInfo
+		want  string
+	}{
+		{
+			shared.IndexInfo{Name: "idx_patients_name", Columns: []string{"name"}},
+			"CREATE INDEX IF NOT EXISTS idx_patients_name ON patients (name)",
+		},
+		{
+			shared.IndexInfo{Name: "uidx_slot", Columns: []string{"doctor_id", "starts_at"}, Unique: true},
+			"CREATE UNIQUE INDEX IF NOT EXISTS uidx_slot ON patients (doctor_id, starts_at)",
+		},
+		{
+			shared.IndexInfo{Name: "idx_patients_tags", Columns: []string{"tags"}, Method: "gin", Concurrently: true},
+			"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_patients_tags ON patients USING gin (tags)",
+		},
+		{
+			shared.IndexInfo{Name: "uidx_email", Columns: []string{"lower(email)"}, Unique: true, Where: "deleted_at IS NULL"},
+			"CREATE UNIQUE INDEX IF NOT EXISTS uidx_email ON patients (lower(email)) WHERE deleted_at IS NULL",
+		},
+	}
+	for _, tt := range tests {
+		if got := mb.BuildCreateIndexSQL("patients", tt.index); got != tt.want {
+			t.Errorf("BuildCreateIndexSQL(%s) = %q, want %q", tt.index.Name, got, tt.want)
+		}
+	}
+
+	step := mb.createIndexStep("patients", tests[2].index)
+	if !step.NonTransactional {
+		t.Error("concurrent index step runs in a transaction")
+	}
+}
+
+func TestBuildCommentSQL(t *testing.T) {
+	mb := NewMigrationBuilder()
+	tests := []struct {
+		comment, want string
+	}{
+		{"Contact address", "COMMENT ON COLUMN clinic.patients.email IS 'Contact address'"},
+		{"Patient's email", "COMMENT ON COLUMN clinic.patients.email IS 'Patient''s email'"},
+		{"", "COMMENT ON COLUMN clinic.patients.email IS NULL"},
+	}
+	for _, tt := range tests {
+		if got := mb.BuildCommentSQL("clinic.patients", shared.ColumnInfo{Name: "email", Comment: tt.comment}); got != tt.want {
+			t.Errorf("BuildCommentSQL(%q) = %q, want %q", tt.comment, got, tt.want)
+		}
+	}
+}
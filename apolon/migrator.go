@@ -0,0 +1,515 @@
+package apolon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MigrationsTable records the versioned migrations applied to a database
+const MigrationsTable = "__apolon_migrations"
+
+// Migration is a versioned schema change with SQL to apply and revert it.
+// Versions are timestamps (20060102150405) so they sort in creation order.
+type Migration struct {
+	Version string
+	Name    string
+	Up      string
+	Down    string
+
+	// NoTransaction runs each statement on its own outside a transaction,
+	// for statements such as CREATE INDEX CONCURRENTLY. Statements must end
+	// with a semicolon at the end of a line.
+	NoTransaction bool
+}
+
+// Checksum identifies the migration's SQL, so changes to applied
+// migrations can be detected
+func (m *Migration) Checksum() string {
+	content := m.Up + "\x00" + m.Down
+	if m.NoTransaction {
+		content += "\x00" + noTransactionMarker
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Migration file section markers; the NoTransaction directive goes above
+// the Up section
+const (
+	upMarker            = "-- +apolon Up"
+	downMarker          = "-- +apolon Down"
+	noTransactionMarker = "-- +apolon NoTransaction"
+)
+
+var (
+	migrationsMu sync.Mutex
+	migrations   = make(map[string]*Migration)
+)
+
+// RegisterMigration makes a migration available to DB.Migrate. It is
+// typically called from init functions of generated migration files and
+// panics if the version is already registered.
+func RegisterMigration(m Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	if _, dup := migrations[m.Version]; dup {
+		panic("apolon: RegisterMigration called twice for version " + m.Version)
+	}
+	migrations[m.Version] = &m
+}
+
+// RegisterMigrations registers the SQL migration files at the root of fsys,
+// usually an embed.FS. Files are named <version>_<name>.sql and hold an
+// Up and an optional Down section:
+//
+//	-- +apolon Up
+//	ALTER TABLE patients ADD COLUMN email TEXT;
+//
+//	-- +apolon Down
+//	ALTER TABLE patients DROP COLUMN email;
+func RegisterMigrations(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return fmt.Errorf("register migrations failed: %w", err)
+	}
+
+	for _, file := range files {
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return fmt.Errorf("register migrations failed: %w", err)
+		}
+		m, err := ParseMigration(file, string(content))
+		if err != nil {
+			return fmt.Errorf("register migrations failed: %w", err)
+		}
+		RegisterMigration(*m)
+	}
+	return nil
+}
+
+// ParseMigration parses a migration file named <version>_<name>.sql
+func ParseMigration(filename, content string) (*Migration, error) {
+	base := strings.TrimSuffix(path.Base(filename), ".sql")
+	version, name, ok := strings.Cut(base, "_")
+	if !ok || version == "" || strings.Trim(version, "0123456789") != "" {
+		return nil, fmt.Errorf("migration file %s: expected <version>_<name>.sql", filename)
+	}
+
+	upStart := strings.Index(content, upMarker)
+	if upStart == -1 {
+		return nil, fmt.Errorf("migration file %s: missing %q section", filename, upMarker)
+	}
+	up := content[upStart+len(upMarker):]
+
+	down := ""
+	if downStart := strings.Index(up, downMarker); downStart != -1 {
+		down = up[downStart+len(downMarker):]
+		up = up[:downStart]
+	}
+
+	return &Migration{
+		Version:       version,
+		Name:          name,
+		Up:            strings.TrimSpace(up),
+		Down:          strings.TrimSpace(down),
+		NoTransaction: strings.Contains(content[:upStart], noTransactionMarker),
+	}, nil
+}
+
+// registeredMigrations returns the registered migrations sorted by version
+func registeredMigrations() []*Migration {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	list := make([]*Migration, 0, len(migrations))
+	for _, m := range migrations {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+// AppliedMigration is a row of the migrations history table
+type AppliedMigration struct {
+	Version  string
+	Name     string
+	Checksum string
+}
+
+// Migrator applies versioned migrations and records them in the
+// migrations history table. Up, Down and Redo hold an advisory lock, so
+// processes starting at once migrate one after another.
+type Migrator struct {
+	db         *DB
+	migrations []*Migration
+}
+
+// Migrator returns a migrator for the registered migrations
+func (db *DB) Migrator() *Migrator {
+	return &Migrator{db: db, migrations: registeredMigrations()}
+}
+
+// NewMigrator creates a migrator for the given migrations instead of the
+// registered ones, e.g. migrations loaded from files by a tool
+func NewMigrator(db *DB, migrations []*Migration) *Migrator {
+	sorted := append([]*Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return &Migrator{db: db, migrations: sorted}
+}
+
+// Migrate applies all pending registered migrations in version order
+func (db *DB) Migrate(ctx context.Context) error {
+	_, err := db.Migrator().Up(ctx)
+	return err
+}
+
+// Migrations returns the migrator's migrations sorted by version
+func (m *Migrator) Migrations() []*Migration {
+	return m.migrations
+}
+
+// Up applies pending migrations in version order, each in its own
+// transaction, and returns the number applied. It refuses to run if an
+// applied migration has changed since it was applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	conn, unlock, err := m.db.lockMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := m.load(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := m.apply(ctx, conn, migration); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Down reverts the last n applied migrations in reverse version order,
+// each in its own transaction, and returns the number reverted
+func (m *Migrator) Down(ctx context.Context, n int) (int, error) {
+	conn, unlock, err := m.db.lockMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := m.load(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < n; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if err := m.revert(ctx, conn, migration); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Redo reverts and reapplies the last applied migration
+func (m *Migrator) Redo(ctx context.Context) error {
+	conn, unlock, err := m.db.lockMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := m.load(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if err := m.revert(ctx, conn, migration); err != nil {
+			return err
+		}
+		return m.apply(ctx, conn, migration)
+	}
+	return fmt.Errorf("redo failed: no applied migrations")
+}
+
+// MigrationStatus describes a migration known to the migrator or recorded
+// in the history table
+type MigrationStatus struct {
+	Version string
+	Name    string
+	Applied bool
+
+	// Modified is set if the migration changed after it was applied
+	Modified bool
+
+	// Missing is set if the migration was applied but is not known to the
+	// migrator, e.g. its file was deleted
+	Missing bool
+}
+
+// Status returns the state of every migration in version order. Unlike
+// the other operations it reports modified migrations instead of failing.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.history(ctx, m.db.conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	known := make(map[string]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+		a, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:  migration.Version,
+			Name:     migration.Name,
+			Applied:  ok,
+			Modified: ok && a.Checksum != migration.Checksum(),
+		})
+	}
+	for version, a := range applied {
+		if !known[version] {
+			statuses = append(statuses, MigrationStatus{Version: version, Name: a.Name, Applied: true, Missing: true})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Script returns an SQL script applying the migrations after version from
+// up to and including version to, for review and manual execution. An
+// empty from starts at the first migration and an empty to ends at the
+// last. Each migration runs in its own transaction that records it in the
+// history table first, so a migration already applied fails on the insert
+// and rolls back without changes. NoTransaction migrations are written as
+// plain statements, so they must be idempotent themselves, e.g. with
+// IF NOT EXISTS.
+func (m *Migrator) Script(from, to string) string {
+	var sb strings.Builder
+	sb.WriteString(historyTableSQL + ";\n")
+
+	for _, migration := range m.migrations {
+		if (from != "" && migration.Version <= from) || (to != "" && migration.Version > to) {
+			continue
+		}
+		record := fmt.Sprintf("INSERT INTO %s (version, name, checksum) VALUES (%s, %s, %s)",
+			MigrationsTable, quoteLiteral(migration.Version), quoteLiteral(migration.Name), quoteLiteral(migration.Checksum()))
+
+		if migration.NoTransaction {
+			fmt.Fprintf(&sb, "\n-- %s_%s (runs outside a transaction)\n%s\n%s ON CONFLICT (version) DO NOTHING;\n",
+				migration.Version, migration.Name, terminate(migration.Up), record)
+			continue
+		}
+		fmt.Fprintf(&sb, "\n-- %s_%s\nBEGIN;\n%s;\n%s\nCOMMIT;\n",
+			migration.Version, migration.Name, record, terminate(migration.Up))
+	}
+	return sb.String()
+}
+
+// terminate ends sql with a semicolon, so the statement after it in a
+// script starts on its own
+func terminate(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if sql == "" || strings.HasSuffix(sql, ";") {
+		return sql
+	}
+	return sql + ";"
+}
+
+// load prepares the history table and returns the applied migrations
+// after checking them for changes
+func (m *Migrator) load(ctx context.Context, q querier) (map[string]AppliedMigration, error) {
+	applied, err := m.history(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.verify(applied); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// history creates the history table if needed and returns the applied
+// migrations keyed by version
+func (m *Migrator) history(ctx context.Context, q querier) (map[string]AppliedMigration, error) {
+	if err := m.ensureHistory(ctx, q); err != nil {
+		return nil, err
+	}
+	return m.applied(ctx, q)
+}
+
+// historyTableSQL creates the migrations history table
+const historyTableSQL = `CREATE TABLE IF NOT EXISTS ` + MigrationsTable + ` (
+    version TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    checksum TEXT NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+)`
+
+// ensureHistory creates the migrations history table if it doesn't exist
+func (m *Migrator) ensureHistory(ctx context.Context, q querier) error {
+	_, err := q.ExecContext(ctx, historyTableSQL)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", MigrationsTable, err)
+	}
+	return nil
+}
+
+// applied returns the applied migrations keyed by version
+func (m *Migrator) applied(ctx context.Context, q querier) (map[string]AppliedMigration, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, name, checksum FROM "+MigrationsTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", MigrationsTable, err)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]AppliedMigration)
+	for rows.Next() {
+		var a AppliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", MigrationsTable, err)
+		}
+		applied[a.Version] = a
+	}
+	return applied, rows.Err()
+}
+
+// verify checks that applied migrations still match their recorded checksum
+func (m *Migrator) verify(applied map[string]AppliedMigration) error {
+	for _, migration := range m.migrations {
+		if a, ok := applied[migration.Version]; ok && a.Checksum != migration.Checksum() {
+			return fmt.Errorf("migration %s_%s was modified after it was applied", migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// apply runs a migration's Up SQL and records it in one transaction
+func (m *Migrator) apply(ctx context.Context, q querier, migration *Migration) error {
+	if migration.NoTransaction {
+		if err := m.execStatements(ctx, q, migration.Up); err != nil {
+			return fmt.Errorf("migration %s_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		if _, err := q.ExecContext(ctx,
+			"INSERT INTO "+MigrationsTable+" (version, name, checksum) VALUES ($1, $2, $3)",
+			migration.Version, migration.Name, migration.Checksum(),
+		); err != nil {
+			return fmt.Errorf("migration %s failed: %w", migration.Version, err)
+		}
+		return nil
+	}
+
+	tx, err := q.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migration %s failed: %w", migration.Version, err)
+	}
+	defer tx.Rollback()
+
+	if migration.Up != "" {
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return fmt.Errorf("migration %s_%s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO "+MigrationsTable+" (version, name, checksum) VALUES ($1, $2, $3)",
+		migration.Version, migration.Name, migration.Checksum(),
+	); err != nil {
+		return fmt.Errorf("migration %s failed: %w", migration.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %s failed: %w", migration.Version, err)
+	}
+	return nil
+}
+
+// revert runs a migration's Down SQL and removes it from the history in
+// one transaction
+func (m *Migrator) revert(ctx context.Context, q querier, migration *Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("migration %s_%s has no Down steps", migration.Version, migration.Name)
+	}
+
+	if migration.NoTransaction {
+		if err := m.execStatements(ctx, q, migration.Down); err != nil {
+			return fmt.Errorf("revert %s_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		if _, err := q.ExecContext(ctx, "DELETE FROM "+MigrationsTable+" WHERE version = $1", migration.Version); err != nil {
+			return fmt.Errorf("revert %s failed: %w", migration.Version, err)
+		}
+		return nil
+	}
+
+	tx, err := q.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("revert %s failed: %w", migration.Version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+		return fmt.Errorf("revert %s_%s failed: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+MigrationsTable+" WHERE version = $1", migration.Version); err != nil {
+		return fmt.Errorf("revert %s failed: %w", migration.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("revert %s failed: %w", migration.Version, err)
+	}
+	return nil
+}
+
+// execStatements runs the statements of a NoTransaction migration one by
+// one, as Postgres runs a multi-statement string in an implicit transaction
+func (m *Migrator) execStatements(ctx context.Context, q querier, sql string) error {
+	for _, statement := range splitStatements(sql) {
+		if _, err := q.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitStatements splits SQL into statements at semicolons ending a line
+func splitStatements(sql string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		current.WriteString(line + "\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
@@ -0,0 +1,151 @@
+package apolon
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMigration(t *testing.T) {
+	content := `-- +apolon Up
+CREATE TABLE patients (id SERIAL PRIMARY KEY);
+
+-- +apolon Down
+DROP TABLE patients;
+`
+	m, err := ParseMigration("migrations/20240101120000_create_patients.sql", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != "20240101120000" || m.Name != "create_patients" {
+		t.Errorf("version, name = %q, %q, want 20240101120000, create_patients", m.Version, m.Name)
+	}
+	if m.Up != "CREATE TABLE patients (id SERIAL PRIMARY KEY);" || m.Down != "DROP TABLE patients;" {
+		t.Errorf("Up = %q, Down = %q", m.Up, m.Down)
+	}
+	if m.NoTransaction {
+		t.Error("NoTransaction set without the directive")
+	}
+
+	m, err = ParseMigration("20240102_index.sql", "-- +apolon NoTransaction\n-- +apolon Up\nCREATE INDEX CONCURRENTLY idx ON patients (name);\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.NoTransaction || m.Down != "" {
+		t.Errorf("NoTransaction = %v, Down = %q, want true and no Down", m.NoTransaction, m.Down)
+	}
+
+	// The directive only counts above the Up section
+	m, err = ParseMigration("20240103_note.sql", "-- +apolon Up\nSELECT 1;\n-- +apolon NoTransaction\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.NoTransaction {
+		t.Error("NoTransaction set by a directive inside the Up section")
+	}
+}
+
+func TestParseMigrationErrors(t *testing.T) {
+	tests := []struct {
+		filename, content, want string
+	}{
+		{"create_patients.sql", "-- +apolon Up\n", "expected <version>_<name>.sql"},
+		{"v1_create_patients.sql", "-- +apolon Up\n", "expected <version>_<name>.sql"},
+		{"_create_patients.sql", "-- +apolon Up\n", "expected <version>_<name>.sql"},
+		{"20240101120000_create_patients.sql", "CREATE TABLE patients ();", "missing \"-- +apolon Up\" section"},
+	}
+	for _, tt := range tests {
+		_, err := ParseMigration(tt.filename, tt.content)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseMigration(%q) error = %v, want %q", tt.filename, err, tt.want)
+		}
+	}
+}
+
+func TestChecksumChangesWithSQL(t *testing.T) {
+	m := Migration{Version: "1", Name: "a", Up: "SELECT 1;", Down: "SELECT 2;"}
+	renamed := Migration{Version: "2", Name: "b", Up: m.Up, Down: m.Down}
+	if m.Checksum() != renamed.Checksum() {
+		t.Error("checksum depends on the version or name")
+	}
+
+	for _, changed := range []Migration{
+		{Up: "SELECT 3;", Down: m.Down},
+		{Up: m.Up, Down: "SELECT 3;"},
+		{Up: m.Up, Down: m.Down, NoTransaction: true},
+		// The separator keeps Up and Down apart
+		{Up: "SELECT 1;SELECT 2;"},
+	} {
+		if changed.Checksum() == m.Checksum() {
+			t.Errorf("checksum of %+v equals the original", changed)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"single", "CREATE INDEX CONCURRENTLY idx ON patients (name);", []string{"CREATE INDEX CONCURRENTLY idx ON patients (name);"}},
+		{
+			"several",
+			"DROP INDEX CONCURRENTLY a;\n\nDROP INDEX CONCURRENTLY b;\n",
+			[]string{"DROP INDEX CONCURRENTLY a;", "DROP INDEX CONCURRENTLY b;"},
+		},
+		{
+			"multi-line statement",
+			"CREATE INDEX CONCURRENTLY idx\n    ON patients (name)\n    WHERE deleted_at IS NULL;\nSELECT 1;",
+			[]string{"CREATE INDEX CONCURRENTLY idx\n    ON patients (name)\n    WHERE deleted_at IS NULL;", "SELECT 1;"},
+		},
+		{
+			"semicolon inside a line",
+			"UPDATE settings SET value = 'a;b';\nSELECT 1",
+			[]string{"UPDATE settings SET value = 'a;b';", "SELECT 1"},
+		},
+		{"empty", "\n  \n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitStatements(tt.sql)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScript(t *testing.T) {
+	migrations := []*Migration{
+		{Version: "3", Name: "index_names", Up: "CREATE INDEX CONCURRENTLY IF NOT EXISTS idx ON patients (name)", NoTransaction: true},
+		{Version: "1", Name: "create_patients", Up: "CREATE TABLE patients (id SERIAL PRIMARY KEY);"},
+		{Version: "2", Name: "o'brien", Up: "SELECT 1;\nDO $apolon$ BEGIN END $apolon$;"},
+		{Version: "4", Name: "later", Up: "SELECT 4;"},
+	}
+	script := NewMigrator(nil, migrations).Script("1", "3")
+
+	if strings.Contains(script, "create_patients") || strings.Contains(script, "later") {
+		t.Errorf("script includes migrations outside (1, 3]:\n%s", script)
+	}
+	if !strings.HasPrefix(script, historyTableSQL+";") {
+		t.Errorf("script does not create the history table first:\n%s", script)
+	}
+
+	m := migrations[2]
+	want := "BEGIN;\nINSERT INTO " + MigrationsTable + " (version, name, checksum) VALUES ('2', 'o''brien', '" + m.Checksum() + "');\n" +
+		m.Up + "\nCOMMIT;\n"
+	if !strings.Contains(script, want) {
+		t.Errorf("script does not run migration 2 as a plain transaction:\n%s\nwant:\n%s", script, want)
+	}
+
+	// Statements without a trailing semicolon are terminated
+	want = "CREATE INDEX CONCURRENTLY IF NOT EXISTS idx ON patients (name);\nINSERT INTO " + MigrationsTable +
+		" (version, name, checksum) VALUES ('3', 'index_names', '" + migrations[0].Checksum() + "') ON CONFLICT (version) DO NOTHING;\n"
+	if !strings.Contains(script, want) {
+		t.Errorf("script does not run migration 3 outside a transaction:\n%s\nwant:\n%s", script, want)
+	}
+	if strings.Index(script, "'2', 'o''brien'") > strings.Index(script, "'3', 'index_names'") {
+		t.Error("migrations are not in version order")
+	}
+}
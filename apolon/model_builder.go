@@ -0,0 +1,181 @@
+package apolon
+
+import (
+	"reflect"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// ModelBuilder configures entity mappings in code, for types that cannot
+// carry apolon tags. Configured values take precedence over tags and feed
+// the same metadata used by queries, tracking and migrations.
+// Generated field accessors only see tags, so columns renamed here should
+// be queried through shared fields built with the configured names.
+type ModelBuilder struct {
+	configs map[reflect.Type]*shared.EntityConfig
+}
+
+// newModelBuilder creates an empty ModelBuilder
+func newModelBuilder() *ModelBuilder {
+	return &ModelBuilder{configs: make(map[reflect.Type]*shared.EntityConfig)}
+}
+
+// EntityTypeBuilder configures the mapping of entities of type T
+type EntityTypeBuilder[T any] struct {
+	config *shared.EntityConfig
+}
+
+// PropertyBuilder configures the mapping of a single field
+type PropertyBuilder struct {
+	config *shared.PropertyConfig
+}
+
+// IndexBuilder configures a single index
+type IndexBuilder struct {
+	config *shared.IndexConfig
+}
+
+// Entity returns the builder for entities of type T, e.g.
+//
+//	apolon.Entity[Patient](mb).ToTable("patients").HasKey("ID")
+func Entity[T any](mb *ModelBuilder) *EntityTypeBuilder[T] {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+
+	config, ok := mb.configs[t]
+	if !ok {
+		config = shared.NewEntityConfig(t)
+		mb.configs[t] = config
+	}
+	return &EntityTypeBuilder[T]{config: config}
+}
+
+// ToTable sets the table name, which may be schema-qualified
+func (b *EntityTypeBuilder[T]) ToTable(name string) *EntityTypeBuilder[T] {
+	b.config.Table = name
+	return b
+}
+
+// HasKey sets the fields that form the primary key, in key order
+func (b *EntityTypeBuilder[T]) HasKey(fields ...string) *EntityTypeBuilder[T] {
+	b.config.Key = fields
+	return b
+}
+
+// Ignore excludes a field from the mapping
+func (b *EntityTypeBuilder[T]) Ignore(field string) *EntityTypeBuilder[T] {
+	b.config.Ignored[field] = true
+	return b
+}
+
+// HasCheckConstraint adds a named table-level CHECK constraint, e.g.
+//
+//	apolon.Entity[Visit](mb).HasCheckConstraint("chk_visit_period", "ends_at > starts_at")
+func (b *EntityTypeBuilder[T]) HasCheckConstraint(name, expression string) *EntityTypeBuilder[T] {
+	b.config.Checks = append(b.config.Checks, shared.CheckInfo{Name: name, Expression: expression})
+	return b
+}
+
+// HasData adds seed rows, upserted by primary key when the entity is
+// migrated, e.g.
+//
+//	apolon.Entity[Country](mb).HasData(Country{Code: "HR", Name: "Croatia"})
+func (b *EntityTypeBuilder[T]) HasData(rows ...T) *EntityTypeBuilder[T] {
+	for _, row := range rows {
+		b.config.Data = append(b.config.Data, row)
+	}
+	return b
+}
+
+// Property returns the builder for the named field
+func (b *EntityTypeBuilder[T]) Property(field string) *PropertyBuilder {
+	return &PropertyBuilder{config: b.config.Property(field)}
+}
+
+// HasIndex adds an index over the named fields, in index order
+func (b *EntityTypeBuilder[T]) HasIndex(fields ...string) *IndexBuilder {
+	index := &shared.IndexConfig{Fields: fields}
+	b.config.Indexes = append(b.config.Indexes, index)
+	return &IndexBuilder{config: index}
+}
+
+// HasExpressionIndex adds an index over SQL expressions, e.g.
+//
+//	apolon.Entity[Patient](mb).HasExpressionIndex("lower(email)").IsUnique()
+func (b *EntityTypeBuilder[T]) HasExpressionIndex(expressions ...string) *IndexBuilder {
+	index := &shared.IndexConfig{Expressions: expressions}
+	b.config.Indexes = append(b.config.Indexes, index)
+	return &IndexBuilder{config: index}
+}
+
+// HasColumnName sets the column the field maps to
+func (b *PropertyBuilder) HasColumnName(name string) *PropertyBuilder {
+	b.config.Column = name
+	return b
+}
+
+// HasColumnType sets the SQL type of the column
+func (b *PropertyBuilder) HasColumnType(sqlType string) *PropertyBuilder {
+	b.config.SQLType = sqlType
+	return b
+}
+
+// IsRequired marks the column NOT NULL
+func (b *PropertyBuilder) IsRequired() *PropertyBuilder {
+	b.config.Required = true
+	return b
+}
+
+// IsUnique adds a UNIQUE constraint to the column
+func (b *PropertyBuilder) IsUnique() *PropertyBuilder {
+	b.config.Unique = true
+	return b
+}
+
+// HasMaxLength limits a string column to n characters (VARCHAR(n))
+func (b *PropertyBuilder) HasMaxLength(n int) *PropertyBuilder {
+	b.config.MaxLength = n
+	return b
+}
+
+// HasComment sets the column comment
+func (b *PropertyBuilder) HasComment(comment string) *PropertyBuilder {
+	b.config.Comment = comment
+	return b
+}
+
+// HasDefaultValueSQL sets the SQL expression used as the column default
+func (b *PropertyBuilder) HasDefaultValueSQL(sql string) *PropertyBuilder {
+	b.config.DefaultValue = &sql
+	return b
+}
+
+// HasName sets the index name, replacing the idx_<table>_<columns> default
+func (b *IndexBuilder) HasName(name string) *IndexBuilder {
+	b.config.Name = name
+	return b
+}
+
+// IsUnique makes the index unique
+func (b *IndexBuilder) IsUnique() *IndexBuilder {
+	b.config.Unique = true
+	return b
+}
+
+// HasMethod sets the index access method, e.g. "gin" or "btree"
+func (b *IndexBuilder) HasMethod(method string) *IndexBuilder {
+	b.config.Method = method
+	return b
+}
+
+// HasFilter makes the index partial, covering only rows matching the
+// SQL predicate, e.g. "deleted_at IS NULL"
+func (b *IndexBuilder) HasFilter(predicate string) *IndexBuilder {
+	b.config.Where = predicate
+	return b
+}
+
+// IsCreatedConcurrently builds the index without blocking writes
+func (b *IndexBuilder) IsCreatedConcurrently() *IndexBuilder {
+	b.config.Concurrently = true
+	return b
+}
@@ -0,0 +1,84 @@
+package apolon
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+type configuredPatient struct {
+	ID    int    `apolon:"id,pk"`
+	Code  string `apolon:"code"`
+	Name  string `apolon:"name"`
+	Notes string `apolon:"notes"`
+}
+
+// openConfiguredDB opens a DB configuring configuredPatient with configure
+func openConfiguredDB(t *testing.T, configure func(b *EntityTypeBuilder[configuredPatient])) *DB {
+	t.Helper()
+	db, err := Open("postgres://localhost/apolon_test?sslmode=disable", OnModelCreating(func(mb *ModelBuilder) {
+		configure(Entity[configuredPatient](mb))
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestModelBuilderOverridesTags(t *testing.T) {
+	db := openConfiguredDB(t, func(b *EntityTypeBuilder[configuredPatient]) {
+		b.ToTable("clinic.people").HasKey("Code").Ignore("Notes")
+		b.Property("Name").HasColumnName("full_name").IsRequired().HasMaxLength(200)
+	})
+
+	model, err := db.models.Model(reflect.TypeOf(configuredPatient{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Table != "clinic.people" {
+		t.Errorf("Table = %q, want clinic.people", model.Table)
+	}
+	if len(model.Key) != 1 || model.Key[0].Name != "Code" {
+		t.Errorf("Key = %v, want Code", model.Key)
+	}
+	if model.FieldByName("Notes") != nil {
+		t.Error("ignored field Notes is mapped")
+	}
+	name := model.FieldByName("Name")
+	if name == nil || name.Column.Name != "full_name" || !name.Column.IsNotNull || name.Column.SQLType != "VARCHAR(200)" {
+		t.Errorf("Name = %+v, want full_name VARCHAR(200) NOT NULL", name)
+	}
+
+	sql, _ := Set[configuredPatient](db).Query().ToSQL()
+	if want := "SELECT id, code, full_name FROM clinic.people"; sql != want {
+		t.Errorf("query = %q, want %q", sql, want)
+	}
+}
+
+func TestModelBuilderRegistriesAreIsolated(t *testing.T) {
+	renamed := openConfiguredDB(t, func(b *EntityTypeBuilder[configuredPatient]) { b.ToTable("people") })
+	other := openConfiguredDB(t, func(b *EntityTypeBuilder[configuredPatient]) { b.ToTable("patients") })
+	plain := openTestDB(t)
+
+	tables := map[*shared.ModelRegistry]string{
+		renamed.models:         "people",
+		other.models:           "patients",
+		shared.DefaultRegistry: "configuredpatients",
+	}
+	for registry, want := range tables {
+		model, err := registry.Model(reflect.TypeOf(configuredPatient{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if model.Table != want {
+			t.Errorf("Table = %q, want %q", model.Table, want)
+		}
+	}
+	// Configuring a DB leaves the default registry untouched, which
+	// unconfigured DBs share
+	if plain.models != shared.DefaultRegistry {
+		t.Error("unconfigured DB does not use the default registry")
+	}
+}
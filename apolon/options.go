@@ -0,0 +1,49 @@
+package apolon
+
+import (
+	"time"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// Option configures a DB when it is opened
+type Option func(*DB)
+
+// WithNamingStrategy sets the strategy used to name untagged tables and
+// columns. Generated field accessors must use the same strategy, see
+// apolon generate --naming.
+func WithNamingStrategy(naming shared.NamingStrategy) Option {
+	return func(db *DB) {
+		db.naming = naming
+	}
+}
+
+// OnModelCreating registers a callback that configures entity mappings in
+// code when the DB is opened, e.g.
+//
+//	apolon.Open(dsn, apolon.OnModelCreating(func(mb *apolon.ModelBuilder) {
+//		apolon.Entity[Patient](mb).ToTable("patients").HasKey("ID").
+//			Property("Name").HasColumnName("full_name").IsRequired().HasMaxLength(200)
+//	}))
+func OnModelCreating(configure func(mb *ModelBuilder)) Option {
+	return func(db *DB) {
+		db.configure = append(db.configure, configure)
+	}
+}
+
+// WithStrictColumnMapping makes queries fail when a result column has no
+// matching field, instead of discarding the column
+func WithStrictColumnMapping() Option {
+	return func(db *DB) {
+		db.strictColumns = true
+	}
+}
+
+// WithMigrationLockTimeout sets how long AutoMigrate and versioned
+// migrations wait for a migration running in another process before
+// failing. The default is one minute.
+func WithMigrationLockTimeout(timeout time.Duration) Option {
+	return func(db *DB) {
+		db.migrationLockTimeout = timeout
+	}
+}
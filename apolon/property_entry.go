@@ -0,0 +1,166 @@
+package apolon
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// PropertyEntry gives access to a single property of a tracked entity
+type PropertyEntry struct {
+	Name  string // Go field name
+	entry *EntityEntry
+	field *shared.Field
+}
+
+// Property returns the entry for the named field.
+// It panics if the field is not mapped, like a misspelled field name in reflect.
+func (e *EntityEntry) Property(name string) *PropertyEntry {
+	field := e.model.FieldByName(name)
+	if field == nil {
+		panic(fmt.Sprintf("apolon: %s has no mapped property %q", e.entityType, name))
+	}
+	return &PropertyEntry{Name: name, entry: e, field: field}
+}
+
+// CurrentValue returns the property's current value on the entity
+func (p *PropertyEntry) CurrentValue() any {
+	return p.entry.fieldValue().FieldByIndex(p.field.Index).Interface()
+}
+
+// SetCurrentValue assigns a new value to the property on the entity.
+// nil assigns the zero value and pointer fields accept values of their
+// element type. It panics if the value cannot be converted to the field's type.
+func (p *PropertyEntry) SetCurrentValue(value any) {
+	field := p.entry.fieldValue().FieldByIndex(p.field.Index)
+	val := reflect.ValueOf(value)
+	if !val.IsValid() {
+		field.Set(reflect.Zero(field.Type()))
+		return
+	}
+	if field.Kind() == reflect.Ptr && !val.Type().ConvertibleTo(field.Type()) &&
+		val.Type().ConvertibleTo(field.Type().Elem()) {
+		ptr := reflect.New(field.Type().Elem())
+		ptr.Elem().Set(val.Convert(field.Type().Elem()))
+		field.Set(ptr)
+		return
+	}
+	if !val.Type().ConvertibleTo(field.Type()) {
+		panic(fmt.Sprintf("apolon: cannot set %s.%s of type %s to %v of type %s",
+			p.entry.entityType, p.Name, field.Type(), value, val.Type()))
+	}
+	field.Set(val.Convert(field.Type()))
+}
+
+// OriginalValue returns the property's value when it was last loaded or
+// saved, or nil if the entity has no snapshot (e.g. Added)
+func (p *PropertyEntry) OriginalValue() any {
+	if p.entry.snapshot == nil {
+		return nil
+	}
+	return reflect.ValueOf(p.entry.snapshot).Elem().FieldByIndex(p.field.Index).Interface()
+}
+
+// IsModified reports whether the property will be written on SaveChanges
+func (p *PropertyEntry) IsModified() bool {
+	if modified, ok := p.entry.modified[p.Name]; ok {
+		return modified
+	}
+	if p.entry.snapshot == nil {
+		return false
+	}
+	return !reflect.DeepEqual(p.CurrentValue(), p.OriginalValue())
+}
+
+// SetModified explicitly includes or excludes the property from the UPDATE
+// issued by SaveChanges. Marking a property of an Unchanged entity as
+// modified moves the entity to Modified.
+func (p *PropertyEntry) SetModified(modified bool) {
+	if p.entry.modified == nil {
+		p.entry.modified = make(map[string]bool)
+	}
+	p.entry.modified[p.Name] = modified
+
+	if modified && p.entry.State == shared.Unchanged {
+		if p.entry.tracker != nil {
+			p.entry.tracker.markModified(p.entry)
+		} else {
+			p.entry.State = shared.Modified
+		}
+	}
+}
+
+// fieldValue returns the addressable struct value of the entity
+func (e *EntityEntry) fieldValue() reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(e.Entity))
+}
+
+// Reload overwrites the entity with its current values from the database,
+// discarding pending changes. If the row no longer exists the entity is
+// detached and an error wrapping sql.ErrNoRows is returned.
+func (e *EntityEntry) Reload(ctx context.Context) error {
+	if e.tracker == nil {
+		return fmt.Errorf("reload failed: %s is not tracked", e.entityType.Name())
+	}
+	if e.State == shared.Added {
+		return fmt.Errorf("reload failed: %s has not been saved", e.entityType.Name())
+	}
+	if e.modelErr != nil {
+		return fmt.Errorf("reload failed: %w", e.modelErr)
+	}
+	if len(e.model.Key) == 0 {
+		return fmt.Errorf("reload failed: %s has no primary key", e.entityType.Name())
+	}
+
+	where, args := keyPredicate(e.model.Key, e.KeyValues(), nil, 1)
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE %s",
+		strings.Join(e.model.Columns(), ", "),
+		e.model.Table,
+		where,
+	)
+
+	if err := e.reloadRow(ctx, query, args); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			e.tracker.Untrack(e.Entity)
+		}
+		return fmt.Errorf("reload failed: %w", err)
+	}
+
+	tracker := e.tracker
+	tracker.mu.Lock()
+	oldState := e.State
+	e.AcceptChanges()
+	tracker.mu.Unlock()
+
+	tracker.dispatch([]trackerEvent{{entry: e, oldState: oldState, newState: shared.Unchanged}})
+	return nil
+}
+
+// reloadRow runs a single-row query and scans the result into the entity,
+// returning sql.ErrNoRows if the query matched nothing
+func (e *EntityEntry) reloadRow(ctx context.Context, query string, args []any) error {
+	db := e.tracker.db
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	fields, err := db.columnFields(e.model, rows)
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	return scanStruct(rows, e.Entity, fields)
+}
@@ -0,0 +1,60 @@
+package apolon
+
+import (
+	"strings"
+	"testing"
+)
+
+type propertyVisit struct {
+	ID        int    `apolon:"id,pk"`
+	Reason    string `apolon:"reason"`
+	PatientID *int64 `apolon:"patient_id"`
+}
+
+// expectPanic runs fn and returns the recovered panic message
+func expectPanic(t *testing.T, fn func()) string {
+	t.Helper()
+	var msg string
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				msg, _ = r.(string)
+			}
+		}()
+		fn()
+	}()
+	if msg == "" {
+		t.Fatal("expected a panic")
+	}
+	return msg
+}
+
+func TestPropertyUnknownName(t *testing.T) {
+	db := openTestDB(t)
+	entry := db.Attach(&propertyVisit{ID: 1})
+
+	msg := expectPanic(t, func() { entry.Property("Reasn").SetModified(true) })
+	if !strings.Contains(msg, `"Reasn"`) || !strings.Contains(msg, "propertyVisit") {
+		t.Errorf("panic message %q does not name the property and type", msg)
+	}
+}
+
+func TestPropertySetCurrentValue(t *testing.T) {
+	db := openTestDB(t)
+	visit := &propertyVisit{ID: 1}
+	entry := db.Attach(visit)
+
+	entry.Property("PatientID").SetCurrentValue(7)
+	if visit.PatientID == nil || *visit.PatientID != 7 {
+		t.Errorf("PatientID = %v, want 7", visit.PatientID)
+	}
+	entry.Property("PatientID").SetCurrentValue(nil)
+	if visit.PatientID != nil {
+		t.Errorf("PatientID = %v, want nil", *visit.PatientID)
+	}
+
+	msg := expectPanic(t, func() { entry.Property("Reason").SetCurrentValue([]int{1}) })
+	if !strings.Contains(msg, "Reason") {
+		t.Errorf("panic message %q does not name the property", msg)
+	}
+}
@@ -1,9 +1,11 @@
 package apolon
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/jkeresman01/apolon/apolon-shared"
 )
@@ -11,6 +13,9 @@ import (
 // Query represents a SELECT query builder
 type Query[T any] struct {
 	apolon     *DB
+	model      *shared.Model
+	err        error // invalid model tags, returned when the query runs
+	ctx        context.Context
 	table      string
 	columns    []string
 	conditions []shared.Condition
@@ -18,18 +23,30 @@ type Query[T any] struct {
 	limit      *int
 	offset     *int
 	tracking   bool // whether to track returned entities
+
+	identityResolution bool // resolve duplicate keys without tracking
+
+	softDeleteColumn string // column holding the deletion timestamp, if any
+	withDeleted      bool   // include soft-deleted rows
+	ignoreFilters    bool   // skip all global query filters
 }
 
 // newQuery creates a new query for the given type
 func newQuery[T any](apolon *DB) *Query[T] {
-	var zero T
-	info := shared.ParseModel(&zero)
-	return &Query[T]{
+	model, err := apolon.models.Model(reflect.TypeOf((*T)(nil)).Elem())
+	q := &Query[T]{
 		apolon:   apolon,
-		table:    info.Table,
-		columns:  info.Fields,
+		model:    model,
+		err:      err,
+		ctx:      context.Background(),
+		table:    model.Table,
+		columns:  model.Columns(),
 		tracking: true, // tracking enabled by default
 	}
+	if model.SoftDelete != nil {
+		q.softDeleteColumn = model.SoftDelete.Column.Name
+	}
+	return q
 }
 
 // Where adds a condition to the query
@@ -59,6 +76,7 @@ func (q *Query[T]) Offset(n int) *Query[T] {
 // AsTracking enables change tracking for returned entities (default)
 func (q *Query[T]) AsTracking() *Query[T] {
 	q.tracking = true
+	q.identityResolution = false
 	return q
 }
 
@@ -66,14 +84,75 @@ func (q *Query[T]) AsTracking() *Query[T] {
 // Use this for read-only queries to improve performance
 func (q *Query[T]) AsNoTracking() *Query[T] {
 	q.tracking = false
+	q.identityResolution = false
+	return q
+}
+
+// AsNoTrackingWithIdentityResolution disables change tracking but still
+// returns a single instance per primary key within the results.
+// Use this for read-only graphs where the same row appears more than once.
+func (q *Query[T]) AsNoTrackingWithIdentityResolution() *Query[T] {
+	q.tracking = false
+	q.identityResolution = true
 	return q
 }
 
+// WithContext sets the context used to execute the query and evaluate query filters
+func (q *Query[T]) WithContext(ctx context.Context) *Query[T] {
+	q.ctx = ctx
+	return q
+}
+
+// WithDeleted includes soft-deleted rows in the results
+func (q *Query[T]) WithDeleted() *Query[T] {
+	q.withDeleted = true
+	return q
+}
+
+// IgnoreQueryFilters disables all global query filters, including soft delete
+func (q *Query[T]) IgnoreQueryFilters() *Query[T] {
+	q.ignoreFilters = true
+	return q
+}
+
+// filterConditions returns the global filters that apply to this query
+func (q *Query[T]) filterConditions() []shared.Condition {
+	if q.ignoreFilters {
+		return nil
+	}
+
+	var filters []shared.Condition
+	if q.softDeleteColumn != "" && !q.withDeleted {
+		filters = append(filters, &shared.NullCondition{Column: q.softDeleteColumn, IsNull: true})
+	}
+
+	filters = append(filters, q.apolon.filters.conditions(q.ctx, q.model.Type)...)
+	return filters
+}
+
+// writeWhere appends the WHERE clause for user conditions and global filters
+func (q *Query[T]) writeWhere(sb *strings.Builder, paramIdx int) []any {
+	conditions := append(q.filterConditions(), q.conditions...)
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	args := []any{}
+	sb.WriteString(" WHERE ")
+	whereParts := make([]string, 0, len(conditions))
+	for _, cond := range conditions {
+		sql, condArgs, nextIdx := cond.ToSQL(paramIdx)
+		whereParts = append(whereParts, sql)
+		args = append(args, condArgs...)
+		paramIdx = nextIdx
+	}
+	sb.WriteString(strings.Join(whereParts, " AND "))
+	return args
+}
+
 // buildSQL constructs the SQL query and arguments
 func (q *Query[T]) buildSQL() (string, []any) {
 	var sb strings.Builder
-	args := []any{}
-	paramIdx := 1
 
 	// SELECT
 	sb.WriteString("SELECT ")
@@ -82,17 +161,7 @@ func (q *Query[T]) buildSQL() (string, []any) {
 	sb.WriteString(q.table)
 
 	// WHERE
-	if len(q.conditions) > 0 {
-		sb.WriteString(" WHERE ")
-		whereParts := make([]string, 0, len(q.conditions))
-		for _, cond := range q.conditions {
-			sql, condArgs, nextIdx := cond.ToSQL(paramIdx)
-			whereParts = append(whereParts, sql)
-			args = append(args, condArgs...)
-			paramIdx = nextIdx
-		}
-		sb.WriteString(strings.Join(whereParts, " AND "))
-	}
+	args := q.writeWhere(&sb, 1)
 
 	// ORDER BY
 	if len(q.orderBys) > 0 {
@@ -124,104 +193,208 @@ func (q *Query[T]) ToSQL() (string, []any) {
 
 // ToSlice executes the query and returns all matching results
 func (q *Query[T]) ToSlice() ([]T, error) {
-	sql, args := q.buildSQL()
+	results, _, err := q.fetch()
+	return results, err
+}
+
+// ToPtrSlice executes the query and returns pointers to the results.
+// Rows resolved to an already-tracked entity share that entity's pointer.
+func (q *Query[T]) ToPtrSlice() ([]*T, error) {
+	_, ptrs, err := q.fetch()
+	return ptrs, err
+}
 
-	rows, err := q.apolon.conn.Query(sql, args...)
+// First returns the first matching result or nil if none found
+func (q *Query[T]) First() (*T, error) {
+	one := 1
+	q.limit = &one
+	_, ptrs, err := q.fetch()
 	if err != nil {
 		return nil, err
 	}
+	if len(ptrs) == 0 {
+		return nil, nil
+	}
+	return ptrs[0], nil
+}
+
+// fetch executes the query and resolves row identities.
+// Tracking queries return the already-tracked instance for rows whose key is
+// tracked, so pending modifications are kept. Identity-resolving no-tracking
+// queries collapse duplicate keys within the result set onto one instance.
+// For every row values[i] holds the same data as *ptrs[i].
+func (q *Query[T]) fetch() ([]T, []*T, error) {
+	if q.err != nil {
+		return nil, nil, q.err
+	}
+
+	sql, args := q.buildSQL()
+
+	rows, err := q.apolon.conn.QueryContext(q.ctx, sql, args...)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer rows.Close()
 
+	fields, err := q.apolon.columnFields(q.model, rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var results []T
 	for rows.Next() {
 		var item T
-		if err := scanStruct(rows, &item); err != nil {
-			return nil, err
+		if err := scanStruct(rows, &item, fields); err != nil {
+			return nil, nil, err
 		}
 		results = append(results, item)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	ptrs := make([]*T, len(results))
+	for i := range results {
+		ptrs[i] = &results[i]
 	}
 
-	// Track entities if tracking is enabled
-	if q.tracking && q.apolon.ChangeTracker != nil {
-		for i := range results {
-			q.apolon.ChangeTracker.Track(&results[i], shared.Unchanged)
+	tracking := q.tracking && q.apolon.ChangeTracker != nil
+	if !tracking && !q.identityResolution {
+		return results, ptrs, nil
+	}
+
+	t := q.model.Type
+	seen := make(map[any]*T)
+
+	for i := range results {
+		values := keyValues(q.model, reflect.ValueOf(&results[i]).Elem())
+		pk, hasKey := comparableKey(values)
+
+		if tracking {
+			if hasKey {
+				if entry := q.apolon.ChangeTracker.GetEntryByKey(t, values...); entry != nil {
+					if tracked, ok := entry.Entity.(*T); ok {
+						results[i] = *tracked
+						ptrs[i] = tracked
+						continue
+					}
+				}
+			}
+			q.apolon.ChangeTracker.track(&results[i], shared.Unchanged, true)
+			continue
+		}
+
+		if hasKey {
+			if prev, ok := seen[pk]; ok {
+				results[i] = *prev
+				ptrs[i] = prev
+				continue
+			}
+			seen[pk] = &results[i]
 		}
 	}
 
-	return results, nil
+	return results, ptrs, nil
 }
 
-// First returns the first matching result or nil if none found
-func (q *Query[T]) First() (*T, error) {
-	one := 1
-	q.limit = &one
-	results, err := q.ToSlice()
-	if err != nil {
-		return nil, err
+// Find finds an entity by its primary key. Composite keys take one value
+// per key field, in key order.
+func (q *Query[T]) Find(keys ...any) (*T, error) {
+	if q.err != nil {
+		return nil, q.err
 	}
-	if len(results) == 0 {
-		return nil, nil
+	if len(q.model.Key) == 0 {
+		return nil, fmt.Errorf("find failed: %s has no primary key", q.model.Type.Name())
+	}
+	if len(keys) != len(q.model.Key) {
+		return nil, fmt.Errorf("find failed: %s has %d key fields, got %d values",
+			q.model.Type.Name(), len(q.model.Key), len(keys))
 	}
-	return &results[0], nil
-}
 
-// Find finds an entity by its primary key
-func (q *Query[T]) Find(pk any) (*T, error) {
-	// First check if entity is already tracked
+	// First check if entity is already tracked. The tracked instance must
+	// pass the query's conditions and filters; conditions that cannot be
+	// evaluated in memory fall back to the database.
 	if q.apolon.ChangeTracker != nil {
-		var zero T
-		t := reflect.TypeOf(zero)
-		if entry := q.apolon.ChangeTracker.GetEntryByKey(t, pk); entry != nil {
+		if entry := q.apolon.ChangeTracker.GetEntryByKey(q.model.Type, keys...); entry != nil {
 			if result, ok := entry.Entity.(*T); ok {
-				return result, nil
+				conditions := append(q.filterConditions(), q.conditions...)
+				matched, known := matchConditions(q.model, reflect.ValueOf(result).Elem(), conditions)
+				if known && matched {
+					return result, nil
+				}
+				if known {
+					return nil, nil
+				}
 			}
 		}
 	}
 
-	// Get PK column name
-	var zero T
-	pkColName := getPKColumnFromType(zero)
-
 	// Query from database
-	q.conditions = append(q.conditions, &shared.SimpleCondition{
-		Column: pkColName,
-		Op:     "=",
-		Value:  pk,
-	})
+	for i, field := range q.model.Key {
+		q.conditions = append(q.conditions, &shared.SimpleCondition{
+			Column: field.Column.Name,
+			Op:     "=",
+			Value:  keys[i],
+		})
+	}
 
 	return q.First()
 }
 
 // Count returns the number of matching rows
 func (q *Query[T]) Count() (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+
 	var sb strings.Builder
-	args := []any{}
-	paramIdx := 1
 
 	sb.WriteString("SELECT COUNT(*) FROM ")
 	sb.WriteString(q.table)
 
-	if len(q.conditions) > 0 {
-		sb.WriteString(" WHERE ")
-		whereParts := make([]string, 0, len(q.conditions))
-		for _, cond := range q.conditions {
-			sql, condArgs, nextIdx := cond.ToSQL(paramIdx)
-			whereParts = append(whereParts, sql)
-			args = append(args, condArgs...)
-			paramIdx = nextIdx
-		}
-		sb.WriteString(strings.Join(whereParts, " AND "))
-	}
+	args := q.writeWhere(&sb, 1)
 
 	var count int
-	err := q.apolon.conn.QueryRow(sb.String(), args...).Scan(&count)
+	err := q.apolon.conn.QueryRowContext(q.ctx, sb.String(), args...).Scan(&count)
 	return count, err
 }
 
+// ExecuteDelete deletes all matching rows in a single statement without
+// loading them. Soft-deleted models are stamped instead of removed.
+// Tracked entities are not affected.
+func (q *Query[T]) ExecuteDelete() (int, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+
+	var sb strings.Builder
+	args := []any{}
+	paramIdx := 1
+
+	if q.softDeleteColumn != "" {
+		sb.WriteString(fmt.Sprintf("UPDATE %s SET %s = $1", q.table, q.softDeleteColumn))
+		args = append(args, time.Now())
+		paramIdx++
+	} else {
+		sb.WriteString("DELETE FROM ")
+		sb.WriteString(q.table)
+	}
+
+	args = append(args, q.writeWhere(&sb, paramIdx)...)
+
+	result, err := q.apolon.conn.ExecContext(q.ctx, sb.String(), args...)
+	if err != nil {
+		return 0, fmt.Errorf("delete failed: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return int(n), nil
+}
+
 // Exists returns true if any matching rows exist
 func (q *Query[T]) Exists() (bool, error) {
 	count, err := q.Count()
@@ -233,58 +406,47 @@ type scanner interface {
 	Scan(dest ...any) error
 }
 
-// scanStruct scans a row into a struct
-func scanStruct(rows scanner, dest any) error {
-	v := reflect.ValueOf(dest).Elem()
-	t := v.Type()
+// columnLister is implemented by result sets that report their columns
+type columnLister interface {
+	Columns() ([]string, error)
+}
 
-	ptrs := []any{}
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
+// columnFields maps the columns of a result set to fields of the model.
+// Columns without a matching field map to nil and are discarded, or cause
+// an error if strict column mapping is enabled.
+func (apolon *DB) columnFields(model *shared.Model, rows columnLister) ([]*shared.Field, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns: %w", err)
+	}
 
-		// Skip unexported fields
-		if !f.IsExported() {
-			continue
+	fields := make([]*shared.Field, len(columns))
+	for i, column := range columns {
+		field := model.FieldByColumn(column)
+		if field == nil {
+			field = model.FieldByColumn(strings.ToLower(column))
 		}
-
-		// Skip fields with apolon:"-"
-		tag := f.Tag.Get("apolon")
-		if tag == "-" {
-			continue
+		if field == nil && apolon.strictColumns {
+			return nil, fmt.Errorf("column %q has no matching field in %s", column, model.Type.Name())
 		}
-
-		ptrs = append(ptrs, v.Field(i).Addr().Interface())
+		fields[i] = field
 	}
-
-	return rows.Scan(ptrs...)
+	return fields, nil
 }
 
-// getPKColumnFromType extracts the PK column name from a type
-func getPKColumnFromType(entity any) string {
-	t := reflect.TypeOf(entity)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-	}
-
-	// Look for field with pk tag
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		tag := field.Tag.Get("apolon")
-		if containsPK(tag) {
-			parts := strings.Split(tag, ",")
-			return parts[0]
-		}
-	}
+// scanStruct scans a row into a struct, storing each column in the field
+// at the same position of fields; columns mapped to nil are discarded
+func scanStruct(rows scanner, dest any, fields []*shared.Field) error {
+	v := reflect.ValueOf(dest).Elem()
 
-	// Default to "id"
-	if field, ok := t.FieldByName("ID"); ok {
-		tag := field.Tag.Get("apolon")
-		if tag != "" {
-			parts := strings.Split(tag, ",")
-			return parts[0]
+	ptrs := make([]any, len(fields))
+	for i, field := range fields {
+		if field == nil {
+			ptrs[i] = new(any)
+			continue
 		}
-		return "id"
+		ptrs[i] = v.FieldByIndex(field.Index).Addr().Interface()
 	}
 
-	return "id"
+	return rows.Scan(ptrs...)
 }
@@ -0,0 +1,135 @@
+package apolon
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+func TestTrackingQueryReturnsTrackedInstance(t *testing.T) {
+	db, fake := openFakeDB(t)
+	fake.SetRows([]string{"id", "name"}, []driver.Value{int64(1), "Ana"})
+
+	patient := &trackedPatient{ID: 1, Name: "Ana"}
+	db.Attach(patient)
+	patient.Name = "Ana Horvat"
+
+	found, err := Set[trackedPatient](db).Query().ToPtrSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0] != patient {
+		t.Fatalf("ToPtrSlice() = %v, want the tracked instance", found)
+	}
+	// The database row does not overwrite the pending change
+	if patient.Name != "Ana Horvat" {
+		t.Errorf("Name = %q, want the modification kept", patient.Name)
+	}
+	if !db.Entry(patient).Property("Name").IsModified() {
+		t.Error("Name is no longer modified")
+	}
+
+	values, err := Set[trackedPatient](db).ToSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0].Name != "Ana Horvat" {
+		t.Errorf("ToSlice() = %+v, want the tracked values", values)
+	}
+}
+
+func TestTrackingQueryTracksNewRows(t *testing.T) {
+	db, fake := openFakeDB(t)
+	fake.SetRows([]string{"id", "name"}, []driver.Value{int64(1), "Ana"}, []driver.Value{int64(2), "Ivo"})
+
+	found, err := Set[trackedPatient](db).Query().ToPtrSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, patient := range found {
+		if entry := db.Entry(patient); entry == nil || entry.State != shared.Unchanged {
+			t.Errorf("%+v entry = %v, want Unchanged", patient, entry)
+		}
+	}
+
+	// Querying again resolves to the same instances
+	again, err := Set[trackedPatient](db).Query().ToPtrSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 2 || again[0] != found[0] || again[1] != found[1] {
+		t.Errorf("second query = %v, want %v", again, found)
+	}
+}
+
+func TestNoTrackingWithIdentityResolution(t *testing.T) {
+	db, fake := openFakeDB(t)
+	fake.SetRows([]string{"id", "name"},
+		[]driver.Value{int64(1), "Ana"}, []driver.Value{int64(2), "Ivo"}, []driver.Value{int64(1), "Ana"})
+
+	found, err := Set[trackedPatient](db).Query().AsNoTrackingWithIdentityResolution().ToPtrSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 || found[0] != found[2] || found[0] == found[1] {
+		t.Errorf("ToPtrSlice() = %v, want rows with the same key to share an instance", found)
+	}
+	if n := len(db.ChangeTracker.Entries()); n != 0 {
+		t.Errorf("tracked %d entries, want none", n)
+	}
+
+	found, err = Set[trackedPatient](db).Query().AsNoTracking().ToPtrSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 || found[0] == found[2] {
+		t.Errorf("AsNoTracking() shares instances: %v", found)
+	}
+}
+
+func TestScanMapsColumnsByName(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []string
+		row     []driver.Value
+		want    trackedPatient
+	}{
+		{"reordered", []string{"name", "id"}, []driver.Value{"Ana", int64(1)}, trackedPatient{ID: 1, Name: "Ana"}},
+		{"upper case", []string{"ID", "NAME"}, []driver.Value{int64(1), "Ana"}, trackedPatient{ID: 1, Name: "Ana"}},
+		{"unknown column ignored", []string{"id", "extra", "name"}, []driver.Value{int64(1), "x", "Ana"}, trackedPatient{ID: 1, Name: "Ana"}},
+		{"missing column left zero", []string{"id"}, []driver.Value{int64(1)}, trackedPatient{ID: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := openFakeDB(t)
+			fake.SetRows(tt.columns, tt.row)
+
+			got, err := Set[trackedPatient](db).Query().AsNoTracking().ToSlice()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0].ID != tt.want.ID || got[0].Name != tt.want.Name || got[0].Tags != nil {
+				t.Errorf("ToSlice() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStrictColumnMappingRejectsUnknownColumns(t *testing.T) {
+	db, fake := openFakeDB(t, WithStrictColumnMapping())
+	fake.SetRows([]string{"id", "extra"}, []driver.Value{int64(1), "x"})
+
+	_, err := Set[trackedPatient](db).ToSlice()
+	if err == nil || !strings.Contains(err.Error(), `column "extra" has no matching field in trackedPatient`) {
+		t.Errorf("ToSlice() error = %v, want the unknown column rejected", err)
+	}
+
+	// Missing columns are allowed
+	fake.SetRows([]string{"id"}, []driver.Value{int64(1)})
+	if _, err := Set[trackedPatient](db).ToSlice(); err != nil {
+		t.Errorf("ToSlice() error = %v, want missing columns allowed", err)
+	}
+}
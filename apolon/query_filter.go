@@ -0,0 +1,214 @@
+package apolon
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// QueryFilter builds a condition that is applied to every query for an entity type.
+// Returning nil means the filter does not apply in the given context.
+type QueryFilter func(ctx context.Context) shared.Condition
+
+// filterRegistry holds global query filters keyed by entity type
+type filterRegistry struct {
+	mu      sync.RWMutex
+	filters map[reflect.Type][]QueryFilter
+}
+
+func newFilterRegistry() *filterRegistry {
+	return &filterRegistry{
+		filters: make(map[reflect.Type][]QueryFilter),
+	}
+}
+
+// HasQueryFilter registers a global query filter for entities of type T.
+// Filters apply to queries, counts, finds, bulk deletes and SaveChanges.
+func HasQueryFilter[T any](apolon *DB, filter QueryFilter) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+
+	apolon.filters.mu.Lock()
+	defer apolon.filters.mu.Unlock()
+
+	apolon.filters.filters[t] = append(apolon.filters.filters[t], filter)
+}
+
+// conditions evaluates all filters registered for the given type
+func (r *filterRegistry) conditions(ctx context.Context, t reflect.Type) []shared.Condition {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	r.mu.RLock()
+	filters := r.filters[t]
+	r.mu.RUnlock()
+
+	var conditions []shared.Condition
+	for _, filter := range filters {
+		if cond := filter(ctx); cond != nil {
+			conditions = append(conditions, cond)
+		}
+	}
+	return conditions
+}
+
+// applyFilters stamps equality filters onto an entity and returns the
+// conditions that must guard its UPDATE or DELETE statement.
+// Inserts get zero-valued filter columns filled in; an entity whose filter
+// column holds a different value is rejected.
+func (apolon *DB) applyFilters(ctx context.Context, entry *EntityEntry) ([]shared.Condition, error) {
+	conditions := apolon.filters.conditions(ctx, entry.entityType)
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+
+	v := reflect.ValueOf(entry.Entity)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	for _, cond := range conditions {
+		for column, expected := range equalityValues(cond) {
+			mapped := entry.model.FieldByColumn(column)
+			if mapped == nil {
+				continue
+			}
+
+			field := v.FieldByIndex(mapped.Index)
+			if field.IsZero() && entry.State == shared.Added && field.CanSet() {
+				val := reflect.ValueOf(expected)
+				if val.IsValid() && val.Type().ConvertibleTo(field.Type()) {
+					field.Set(val.Convert(field.Type()))
+				}
+				continue
+			}
+
+			if !valuesEqual(field.Interface(), expected) {
+				return nil, fmt.Errorf("%s %v violates query filter on %s: got %v, expected %v",
+					entry.entityType.Name(), entry.GetPrimaryKey(), column, field.Interface(), expected)
+			}
+		}
+	}
+
+	return conditions, nil
+}
+
+// matchConditions evaluates conditions against an entity struct value.
+// known is false if any condition cannot be evaluated in memory, e.g. a
+// comparison other than equality or a column without a field.
+func matchConditions(model *shared.Model, v reflect.Value, conditions []shared.Condition) (matched, known bool) {
+	matched = true
+	for _, cond := range conditions {
+		ok, isKnown := matchCondition(model, v, cond)
+		if !isKnown {
+			return false, false
+		}
+		matched = matched && ok
+	}
+	return matched, true
+}
+
+// matchCondition evaluates a single condition against an entity struct value
+func matchCondition(model *shared.Model, v reflect.Value, cond shared.Condition) (matched, known bool) {
+	switch c := cond.(type) {
+	case *shared.SimpleCondition:
+		field := model.FieldByColumn(c.Column)
+		if field == nil {
+			return false, false
+		}
+		equal := valuesEqual(v.FieldByIndex(field.Index).Interface(), c.Value)
+		switch c.Op {
+		case "=":
+			return equal, true
+		case "<>", "!=":
+			return !equal, true
+		}
+	case *shared.InCondition:
+		field := model.FieldByColumn(c.Column)
+		if field == nil {
+			return false, false
+		}
+		value := v.FieldByIndex(field.Index).Interface()
+		for _, candidate := range c.Values {
+			if valuesEqual(value, candidate) {
+				return true, true
+			}
+		}
+		return false, true
+	case *shared.NullCondition:
+		field := model.FieldByColumn(c.Column)
+		if field == nil {
+			return false, false
+		}
+		return isNullValue(v.FieldByIndex(field.Index).Interface()) == c.IsNull, true
+	case *shared.AndCondition:
+		return matchConditions(model, v, c.Conditions)
+	case *shared.OrCondition:
+		for _, inner := range c.Conditions {
+			ok, isKnown := matchCondition(model, v, inner)
+			if !isKnown {
+				return false, false
+			}
+			if ok {
+				return true, true
+			}
+		}
+		return false, true
+	case *shared.NotCondition:
+		ok, isKnown := matchCondition(model, v, c.Condition)
+		return !ok, isKnown
+	}
+	return false, false
+}
+
+// isNullValue reports whether a field value is stored as SQL NULL
+func isNullValue(value any) bool {
+	if valuer, ok := value.(driver.Valuer); ok {
+		stored, err := valuer.Value()
+		return err == nil && stored == nil
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// equalityValues collects column = value pairs from a filter condition
+func equalityValues(cond shared.Condition) map[string]any {
+	values := make(map[string]any)
+
+	switch c := cond.(type) {
+	case *shared.SimpleCondition:
+		if c.Op == "=" {
+			values[c.Column] = c.Value
+		}
+	case *shared.AndCondition:
+		for _, inner := range c.Conditions {
+			for column, value := range equalityValues(inner) {
+				values[column] = value
+			}
+		}
+	}
+
+	return values
+}
+
+// valuesEqual compares two values, converting b to the type of a when possible
+func valuesEqual(a, b any) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !va.IsValid() || !vb.IsValid() {
+		return !va.IsValid() && !vb.IsValid()
+	}
+	if vb.Type() != va.Type() && vb.Type().ConvertibleTo(va.Type()) {
+		vb = vb.Convert(va.Type())
+	}
+	return reflect.DeepEqual(va.Interface(), vb.Interface())
+}
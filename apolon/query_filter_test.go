@@ -0,0 +1,61 @@
+package apolon
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+type filteredVisit struct {
+	ID        int        `apolon:"id,pk"`
+	TenantID  int        `apolon:"tenant_id"`
+	Status    string     `apolon:"status"`
+	DeletedAt *time.Time `apolon:"deleted_at,softdelete"`
+}
+
+func TestMatchConditions(t *testing.T) {
+	model, err := shared.DefaultRegistry.ModelOf(filteredVisit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	live := filteredVisit{ID: 1, TenantID: 7, Status: "open"}
+	deleted := filteredVisit{ID: 2, TenantID: 7, Status: "open", DeletedAt: &now}
+
+	notDeleted := &shared.NullCondition{Column: "deleted_at", IsNull: true}
+	tenant := func(id any) shared.Condition {
+		return &shared.SimpleCondition{Column: "tenant_id", Op: "=", Value: id}
+	}
+
+	tests := []struct {
+		name       string
+		entity     filteredVisit
+		conditions []shared.Condition
+		matched    bool
+		known      bool
+	}{
+		{"no conditions", live, nil, true, true},
+		{"soft delete filter passes", live, []shared.Condition{notDeleted}, true, true},
+		{"soft delete filter rejects", deleted, []shared.Condition{notDeleted}, false, true},
+		{"same tenant", live, []shared.Condition{tenant(7)}, true, true},
+		{"other tenant", live, []shared.Condition{tenant(8)}, false, true},
+		{"numeric conversion", live, []shared.Condition{tenant(int64(7))}, true, true},
+		{"in", live, []shared.Condition{&shared.InCondition{Column: "status", Values: []any{"closed", "open"}}}, true, true},
+		{"or", live, []shared.Condition{shared.Or(tenant(1), tenant(7))}, true, true},
+		{"not", live, []shared.Condition{shared.Not(tenant(7))}, false, true},
+		{"unknown operator", live, []shared.Condition{&shared.SimpleCondition{Column: "tenant_id", Op: ">", Value: 1}}, false, false},
+		{"unknown column", live, []shared.Condition{tenant(7), &shared.SimpleCondition{Column: "missing", Op: "=", Value: 1}}, false, false},
+		{"like", live, []shared.Condition{&shared.LikeCondition{Column: "status", Pattern: "o%"}}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, known := matchConditions(model, reflect.ValueOf(tt.entity), tt.conditions)
+			if matched != tt.matched || known != tt.known {
+				t.Errorf("matchConditions() = %v, %v, want %v, %v", matched, known, tt.matched, tt.known)
+			}
+		})
+	}
+}
@@ -0,0 +1,529 @@
+package apolon
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	shared "github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// MigrationStep is a single DDL statement bringing the database closer to
+// the model
+type MigrationStep struct {
+	SQL         string
+	Description string
+
+	// Destructive steps may lose data, e.g. dropping a column or changing
+	// its type, or fail on existing rows, e.g. setting NOT NULL, and are
+	// only applied when explicitly allowed
+	Destructive bool
+
+	// NonTransactional steps cannot run inside a transaction, e.g.
+	// CREATE INDEX CONCURRENTLY
+	NonTransactional bool
+
+	// Skipped steps are destructive steps a migration plan lists but
+	// AutoMigrateWith does not run, as the plan's options do not allow them
+	Skipped bool
+
+	// Args are the values of the statement's placeholders, e.g. for
+	// upserting seed rows
+	Args []any
+}
+
+// MigrateOptions configures AutoMigrateWith
+type MigrateOptions struct {
+	// AllowDestructive applies destructive steps; otherwise they are skipped
+	AllowDestructive bool
+}
+
+// createTableSteps returns the steps creating a table that does not exist
+func (mb *MigrationBuilder) createTableSteps(schema *shared.SchemaInfo) []MigrationStep {
+	var steps []MigrationStep
+
+	if schema.Schema != "" {
+		steps = append(steps, MigrationStep{
+			SQL:         mb.BuildCreateSchemaSQL(schema.Schema),
+			Description: "create schema " + schema.Schema,
+		})
+	}
+	steps = append(steps, MigrationStep{
+		SQL:         mb.BuildCreateTableSQL(schema),
+		Description: "create table " + schema.Table,
+	})
+	for _, index := range schema.Indexes {
+		steps = append(steps, mb.createIndexStep(schema.Table, index))
+	}
+	for _, col := range schema.Columns {
+		if col.Comment != "" {
+			steps = append(steps, mb.commentStep(schema.Table, col))
+		}
+	}
+	return steps
+}
+
+// diffTableSteps returns the steps altering the current definition of a
+// table to match the target. Columns, constraints and indexes are matched
+// by name; columns missing from the target are dropped, while extra checks
+// and indexes are only dropped if prune is set. Checks and indexes whose
+// definition changed are dropped and created again. Foreign keys are added
+// but never dropped here, see diffSchemas.
+func (mb *MigrationBuilder) diffTableSteps(current, target *shared.SchemaInfo, prune bool) []MigrationStep {
+	var steps []MigrationStep
+	table := target.Table
+
+	for _, col := range target.Columns {
+		existing := findColumn(current, col.Name)
+		if existing == nil {
+			steps = append(steps, MigrationStep{
+				SQL:         fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, mb.buildColumnDefinition(col)),
+				Description: fmt.Sprintf("add column %s.%s", table, col.Name),
+			})
+			if col.Comment != "" {
+				steps = append(steps, mb.commentStep(table, col))
+			}
+			continue
+		}
+		steps = append(steps, mb.diffColumnSteps(table, *existing, col, prune)...)
+	}
+
+	for _, col := range current.Columns {
+		if findColumn(target, col.Name) == nil {
+			steps = append(steps, MigrationStep{
+				SQL:         fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, col.Name),
+				Description: fmt.Sprintf("drop column %s.%s", table, col.Name),
+				Destructive: true,
+			})
+		}
+	}
+
+	currentChecks := current.CheckConstraints()
+	targetChecks := target.CheckConstraints()
+	for _, check := range targetChecks {
+		existing := findCheck(currentChecks, check.Name)
+		if existing != nil && normalizeExpression(existing.Expression) == normalizeExpression(check.Expression) {
+			continue
+		}
+		// Existing rows may violate the check, so the drop of a changed one
+		// is skipped along with the add
+		if existing != nil {
+			drop := mb.dropConstraintStep(table, check.Name)
+			drop.Destructive = true
+			steps = append(steps, drop)
+		}
+		steps = append(steps, MigrationStep{
+			SQL:         fmt.Sprintf("ALTER TABLE %s ADD %s", table, mb.buildCheckDefinition(check)),
+			Description: fmt.Sprintf("add check %s on %s", check.Name, table),
+			Destructive: true,
+		})
+	}
+
+	for _, fk := range target.ForeignKeys {
+		if !hasConstraint(current.ForeignKeys, fk.Name) {
+			steps = append(steps, MigrationStep{
+				SQL:         fmt.Sprintf("ALTER TABLE %s ADD %s", table, mb.buildForeignKeyDefinition(fk)),
+				Description: fmt.Sprintf("add foreign key %s on %s", fk.Name, table),
+			})
+		}
+	}
+
+	for _, index := range target.Indexes {
+		existing := findIndex(current.Indexes, index.Name)
+		if existing != nil && sameIndex(*existing, index) {
+			continue
+		}
+		if existing != nil {
+			// Dropped in or outside the transaction like the index is created
+			dropped := *existing
+			dropped.Concurrently = index.Concurrently
+			steps = append(steps, mb.dropIndexStep(current.Schema, table, dropped))
+		}
+		steps = append(steps, mb.createIndexStep(table, index))
+	}
+
+	if !prune {
+		return steps
+	}
+
+	for _, check := range currentChecks {
+		if findCheck(targetChecks, check.Name) == nil {
+			steps = append(steps, mb.dropConstraintStep(table, check.Name))
+		}
+	}
+	for _, index := range current.Indexes {
+		if findIndex(target.Indexes, index.Name) == nil {
+			steps = append(steps, mb.dropIndexStep(current.Schema, table, index))
+		}
+	}
+
+	return steps
+}
+
+// diffColumnSteps returns the steps altering an existing column's type,
+// nullability, default, uniqueness and comment. Unique constraints are
+// only dropped if prune is set.
+func (mb *MigrationBuilder) diffColumnSteps(table string, current, target shared.ColumnInfo, prune bool) []MigrationStep {
+	var steps []MigrationStep
+	alter := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s ", table, target.Name)
+	column := table + "." + target.Name
+
+	if normalizeSQLType(current.SQLType) != normalizeSQLType(target.SQLType) {
+		sqlType := columnType(target.SQLType)
+		steps = append(steps, MigrationStep{
+			SQL:         alter + fmt.Sprintf("TYPE %s USING %s::%s", sqlType, target.Name, sqlType),
+			Description: fmt.Sprintf("change type of %s from %s to %s", column, current.SQLType, sqlType),
+			Destructive: true,
+		})
+	}
+
+	if target.IsNotNull && !current.IsNotNull {
+		steps = append(steps, MigrationStep{
+			SQL:         alter + "SET NOT NULL",
+			Description: "set not null on " + column,
+			Destructive: true,
+		})
+	} else if !target.IsNotNull && current.IsNotNull && !current.IsPrimaryKey {
+		steps = append(steps, MigrationStep{
+			SQL:         alter + "DROP NOT NULL",
+			Description: "drop not null on " + column,
+		})
+	}
+
+	if step, ok := mb.diffDefault(alter, column, current, target); ok {
+		steps = append(steps, step)
+	}
+
+	// Postgres names inline UNIQUE constraints <table>_<column>_key
+	_, name := shared.SplitTableName(table)
+	uniqueName := name + "_" + target.Name + "_key"
+	if target.IsUnique && !target.IsPrimaryKey && !current.IsUnique {
+		steps = append(steps, MigrationStep{
+			SQL:         fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s UNIQUE (%s)", table, uniqueName, target.Name),
+			Description: "add unique constraint on " + column,
+		})
+	} else if prune && !target.IsUnique && current.IsUnique && !current.IsPrimaryKey {
+		steps = append(steps, mb.dropConstraintStep(table, uniqueName))
+	}
+
+	// A comment removed from the model is removed from the column
+	if target.Comment != current.Comment {
+		steps = append(steps, mb.commentStep(table, target))
+	}
+
+	return steps
+}
+
+// diffDefault returns the step setting or dropping a column default. The
+// sequence default of a SERIAL column is not part of the model and is kept.
+func (mb *MigrationBuilder) diffDefault(alter, column string, current, target shared.ColumnInfo) (MigrationStep, bool) {
+	switch {
+	case target.DefaultValue != nil:
+		if current.DefaultValue != nil && normalizeDefault(*current.DefaultValue) == normalizeDefault(*target.DefaultValue) {
+			return MigrationStep{}, false
+		}
+		return MigrationStep{
+			SQL:         alter + "SET DEFAULT " + *target.DefaultValue,
+			Description: "set default on " + column,
+		}, true
+	case current.DefaultValue != nil && !strings.HasPrefix(*current.DefaultValue, "nextval("):
+		return MigrationStep{
+			SQL:         alter + "DROP DEFAULT",
+			Description: "drop default on " + column,
+		}, true
+	}
+	return MigrationStep{}, false
+}
+
+// createIndexStep returns the step creating an index
+func (mb *MigrationBuilder) createIndexStep(table string, index shared.IndexInfo) MigrationStep {
+	return MigrationStep{
+		SQL:              mb.BuildCreateIndexSQL(table, index),
+		Description:      fmt.Sprintf("create index %s on %s", index.Name, table),
+		NonTransactional: index.Concurrently,
+	}
+}
+
+// dropIndexStep returns the step dropping an index of a table in schema
+func (mb *MigrationBuilder) dropIndexStep(schema, table string, index shared.IndexInfo) MigrationStep {
+	concurrently := ""
+	if index.Concurrently {
+		concurrently = "CONCURRENTLY "
+	}
+	return MigrationStep{
+		SQL:              "DROP INDEX " + concurrently + "IF EXISTS " + qualifiedName(schema, index.Name),
+		Description:      fmt.Sprintf("drop index %s on %s", index.Name, table),
+		NonTransactional: index.Concurrently,
+	}
+}
+
+// dropConstraintStep returns the step dropping a named table constraint
+func (mb *MigrationBuilder) dropConstraintStep(table, name string) MigrationStep {
+	return MigrationStep{
+		SQL:         fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s", table, name),
+		Description: fmt.Sprintf("drop constraint %s on %s", name, table),
+	}
+}
+
+// commentStep returns the step setting a column comment, or removing it
+// if the comment is empty
+func (mb *MigrationBuilder) commentStep(table string, col shared.ColumnInfo) MigrationStep {
+	description := "comment on %s.%s"
+	if col.Comment == "" {
+		description = "drop comment on %s.%s"
+	}
+	return MigrationStep{
+		SQL:         mb.BuildCommentSQL(table, col),
+		Description: fmt.Sprintf(description, table, col.Name),
+	}
+}
+
+// hasConstraint reports whether a foreign key with the given name exists
+func hasConstraint(fks []shared.ForeignKeyInfo, name string) bool {
+	for _, fk := range fks {
+		if fk.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// findCheck returns the check with the given name, or nil
+func findCheck(checks []shared.CheckInfo, name string) *shared.CheckInfo {
+	for i := range checks {
+		if checks[i].Name == name {
+			return &checks[i]
+		}
+	}
+	return nil
+}
+
+// qualifiedName prefixes name with schema if it is set
+func qualifiedName(schema, name string) string {
+	if schema == "" {
+		return name
+	}
+	return schema + "." + name
+}
+
+// findIndex returns the index with the given name, or nil
+func findIndex(indexes []shared.IndexInfo, name string) *shared.IndexInfo {
+	for i := range indexes {
+		if indexes[i].Name == name {
+			return &indexes[i]
+		}
+	}
+	return nil
+}
+
+// sameIndex reports whether two indexes have the same uniqueness, access
+// method, columns and predicate
+func sameIndex(a, b shared.IndexInfo) bool {
+	if a.Unique != b.Unique || indexMethod(a) != indexMethod(b) || len(a.Columns) != len(b.Columns) {
+		return false
+	}
+	for i := range a.Columns {
+		if normalizeIndexColumn(a.Columns[i]) != normalizeIndexColumn(b.Columns[i]) {
+			return false
+		}
+	}
+	return normalizeExpression(a.Where) == normalizeExpression(b.Where)
+}
+
+// indexMethod returns the access method of an index, btree by default
+func indexMethod(index shared.IndexInfo) string {
+	if index.Method == "" {
+		return "btree"
+	}
+	return strings.ToLower(index.Method)
+}
+
+// columnType returns the storage type of a column type, which differs
+// for the SERIAL pseudo-types
+func columnType(sqlType string) string {
+	switch strings.ToUpper(sqlType) {
+	case "SMALLSERIAL":
+		return "SMALLINT"
+	case "SERIAL":
+		return "INTEGER"
+	case "BIGSERIAL":
+		return "BIGINT"
+	}
+	return sqlType
+}
+
+// sqlTypeAliases maps type names to the spelling used by format_type
+var sqlTypeAliases = map[string]string{
+	"int":         "integer",
+	"int4":        "integer",
+	"int8":        "bigint",
+	"int2":        "smallint",
+	"varchar":     "character varying",
+	"char":        "character",
+	"bool":        "boolean",
+	"float8":      "double precision",
+	"float4":      "real",
+	"decimal":     "numeric",
+	"timestamptz": "timestamp with time zone",
+	"timestamp":   "timestamp without time zone",
+	"timetz":      "time with time zone",
+	"time":        "time without time zone",
+}
+
+var typeModifier = regexp.MustCompile(`^([a-z0-9 ]+?)\s*(\(.*\))?$`)
+
+// normalizeSQLType reduces a column type to the spelling reported by
+// format_type so model and database types can be compared
+func normalizeSQLType(sqlType string) string {
+	sqlType = strings.ToLower(columnType(strings.Join(strings.Fields(sqlType), " ")))
+
+	match := typeModifier.FindStringSubmatch(sqlType)
+	if match == nil {
+		return sqlType
+	}
+	name, modifier := match[1], strings.ReplaceAll(match[2], " ", "")
+	if alias, ok := sqlTypeAliases[name]; ok {
+		name = alias
+	}
+	return name + modifier
+}
+
+var defaultCast = regexp.MustCompile(`::[a-z ]+(\(\d+(,\d+)?\))?(\[\])?$`)
+
+// normalizeDefault strips the casts Postgres adds to stored defaults, e.g.
+// 'active'::character varying, so they compare equal to the declared value
+func normalizeDefault(value string) string {
+	return defaultCast.ReplaceAllString(strings.TrimSpace(value), "")
+}
+
+var expressionCast = regexp.MustCompile(`(?i)::\s*(character varying|timestamp with(out)? time zone|time with(out)? time zone|double precision|[a-z_][a-z0-9_]*)(\(\d+(,\s*\d+)?\))?(\[\])?`)
+
+// normalizeExpression reduces a check expression or index predicate to a
+// form in which the declared expression and the one deparsed by Postgres,
+// e.g. ((status)::text <> 'closed'::text) for status <> 'closed', compare
+// equal: casts and parentheses are removed, tokens are separated by single
+// spaces and IN lists are written as = ANY arrays, as Postgres stores them.
+func normalizeExpression(expr string) string {
+	expr = expressionCast.ReplaceAllString(expr, "")
+	tokens := rewriteInLists(expressionTokens(expr))
+
+	var kept []string
+	for _, token := range tokens {
+		if token == "!=" {
+			token = "<>"
+		}
+		if token != "(" && token != ")" {
+			kept = append(kept, token)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// expressionTokens splits an expression into lower-cased words, operators,
+// punctuation and string literals, which keep their case
+func expressionTokens(expr string) []string {
+	const operatorChars = "+-*/<>=~!@#%^&|`?"
+
+	var tokens []string
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '\'' || r == '"':
+			// Doubled quotes are escapes inside the literal
+			for i++; i < len(runes); i++ {
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						i++
+						continue
+					}
+					i++
+					break
+				}
+			}
+			tokens = append(tokens, string(runes[start:i]))
+			continue
+		case strings.ContainsRune(operatorChars, r):
+			for i < len(runes) && strings.ContainsRune(operatorChars, runes[i]) {
+				i++
+			}
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '$':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || strings.ContainsRune("_.$", runes[i])) {
+				i++
+			}
+		default:
+			i++
+		}
+		tokens = append(tokens, strings.ToLower(string(runes[start:i])))
+	}
+	return tokens
+}
+
+// rewriteInLists rewrites x IN (a, b) as x = ANY (ARRAY[a, b]) and
+// x NOT IN (a, b) as x <> ALL (ARRAY[a, b])
+func rewriteInLists(tokens []string) []string {
+	var out []string
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] != "in" || i+1 >= len(tokens) || tokens[i+1] != "(" {
+			out = append(out, tokens[i])
+			continue
+		}
+		end := closingParen(tokens, i+1)
+		if end == -1 {
+			out = append(out, tokens[i])
+			continue
+		}
+
+		operator := []string{"=", "any"}
+		if len(out) > 0 && out[len(out)-1] == "not" {
+			out = out[:len(out)-1]
+			operator = []string{"<>", "all"}
+		}
+		out = append(out, operator...)
+		out = append(out, "(", "array", "[")
+		out = append(out, rewriteInLists(tokens[i+2:end])...)
+		out = append(out, "]", ")")
+		i = end
+	}
+	return out
+}
+
+// closingParen returns the index of the parenthesis closing the one at
+// open, or -1 if it is not closed
+func closingParen(tokens []string, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// normalizeIndexColumn normalizes an index column like normalizeExpression,
+// dropping the default ordering that Postgres omits
+func normalizeIndexColumn(column string) string {
+	column = strings.Join(strings.Fields(column), " ")
+	lower := strings.ToLower(column)
+	switch {
+	case strings.HasSuffix(lower, " desc nulls first"):
+		column = column[:len(column)-len(" nulls first")]
+	case strings.HasSuffix(lower, " nulls last") && !strings.HasSuffix(lower, " desc nulls last"):
+		column = column[:len(column)-len(" nulls last")]
+	}
+	if strings.HasSuffix(strings.ToLower(column), " asc") {
+		column = column[:len(column)-len(" asc")]
+	}
+	return normalizeExpression(column)
+}
@@ -0,0 +1,322 @@
+package apolon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// stepDescriptions returns the descriptions of steps, marking destructive ones
+func stepDescriptions(steps []MigrationStep) []string {
+	descriptions := make([]string, len(steps))
+	for i, step := range steps {
+		descriptions[i] = step.Description
+		if step.Destructive {
+			descriptions[i] += " (destructive)"
+		}
+	}
+	return descriptions
+}
+
+func TestNormalizeSQLType(t *testing.T) {
+	tests := []struct {
+		model, database string
+	}{
+		{"INTEGER", "integer"},
+		{"INT", "integer"},
+		{"SERIAL", "integer"},
+		{"BIGSERIAL", "bigint"},
+		{"VARCHAR(100)", "character varying(100)"},
+		{"NUMERIC(10, 2)", "numeric(10,2)"},
+		{"TIMESTAMPTZ", "timestamp with time zone"},
+		{"TIMESTAMP  WITH TIME ZONE", "timestamp with time zone"},
+		{"DOUBLE PRECISION", "double precision"},
+		{"jsonb", "jsonb"},
+	}
+	for _, tt := range tests {
+		if got, want := normalizeSQLType(tt.model), normalizeSQLType(tt.database); got != want {
+			t.Errorf("normalizeSQLType(%q) = %q, normalizeSQLType(%q) = %q", tt.model, got, tt.database, want)
+		}
+	}
+
+	if normalizeSQLType("VARCHAR(100)") == normalizeSQLType("character varying(200)") {
+		t.Error("types of different lengths compare equal")
+	}
+}
+
+func TestNormalizeDefault(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"'active'::character varying", "'active'"},
+		{"'{}'::jsonb", "'{}'"},
+		{"'a'::character varying(10)", "'a'"},
+		{"'{}'::text[]", "'{}'"},
+		{" 0 ", "0"},
+		{"now()", "now()"},
+	}
+	for _, tt := range tests {
+		if got := normalizeDefault(tt.value); got != tt.want {
+			t.Errorf("normalizeDefault(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDiffTableSteps(t *testing.T) {
+	mb := NewMigrationBuilder()
+	active, zero := "'active'", "0"
+	activeCast, sequence := "'active'::text", "nextval('patients_id_seq'::regclass)"
+
+	// As introspected from pg_catalog
+	current := &shared.SchemaInfo{
+		Table: "patients",
+		Columns: []shared.ColumnInfo{
+			{Name: "id", SQLType: "integer", IsPrimaryKey: true, IsNotNull: true, DefaultValue: &sequence},
+			{Name: "name", SQLType: "character varying(100)", IsNotNull: true},
+			{Name: "status", SQLType: "text", DefaultValue: &activeCast},
+			{Name: "age", SQLType: "integer", DefaultValue: &zero},
+			{Name: "email", SQLType: "text", IsUnique: true},
+			{Name: "notes", SQLType: "text"},
+		},
+		Checks:  []shared.CheckInfo{{Name: "chk_patients_extra", Expression: "((age > 0))"}},
+		Indexes: []shared.IndexInfo{{Name: "idx_patients_extra", Method: "btree", Columns: []string{"age"}}},
+	}
+	target := &shared.SchemaInfo{
+		Table: "patients",
+		Columns: []shared.ColumnInfo{
+			{Name: "id", SQLType: "SERIAL", IsPrimaryKey: true, IsNotNull: true},
+			{Name: "name", SQLType: "VARCHAR(100)"},
+			{Name: "status", SQLType: "TEXT", IsNotNull: true, DefaultValue: &active},
+			{Name: "age", SQLType: "BIGINT"},
+			{Name: "email", SQLType: "TEXT", Comment: "Contact address"},
+			{Name: "phone", SQLType: "TEXT", IsUnique: true},
+		},
+	}
+
+	want := []string{
+		"drop not null on patients.name",
+		"set not null on patients.status (destructive)",
+		"change type of patients.age from integer to BIGINT (destructive)",
+		"drop default on patients.age",
+		"comment on patients.email",
+		"add column patients.phone",
+		"drop column patients.notes (destructive)",
+	}
+	if got := stepDescriptions(mb.diffTableSteps(current, target, false)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("diffTableSteps() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Pruning also drops constraints and indexes the target does not declare
+	want = []string{
+		"drop not null on patients.name",
+		"set not null on patients.status (destructive)",
+		"change type of patients.age from integer to BIGINT (destructive)",
+		"drop default on patients.age",
+		"drop constraint patients_email_key on patients",
+		"comment on patients.email",
+		"add column patients.phone",
+		"drop column patients.notes (destructive)",
+		"drop constraint chk_patients_extra on patients",
+		"drop index idx_patients_extra on patients",
+	}
+	if got := stepDescriptions(mb.diffTableSteps(current, target, true)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("diffTableSteps() with prune =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if steps := mb.diffTableSteps(target, target, true); len(steps) != 0 {
+		t.Errorf("diffTableSteps() of an unchanged table = %v, want none", stepDescriptions(steps))
+	}
+}
+
+func TestDiffSchemas(t *testing.T) {
+	clinics := &shared.SchemaInfo{
+		Table:   "clinics",
+		Columns: []shared.ColumnInfo{{Name: "id", SQLType: "SERIAL", IsPrimaryKey: true}},
+	}
+	patients := &shared.SchemaInfo{
+		Table: "patients",
+		Columns: []shared.ColumnInfo{
+			{Name: "id", SQLType: "SERIAL", IsPrimaryKey: true},
+			{Name: "clinic_id", SQLType: "INTEGER"},
+		},
+		ForeignKeys: []shared.ForeignKeyInfo{
+			{Name: "fk_patients_clinic_id", Columns: []string{"clinic_id"}, RefTable: "clinics", RefColumns: []string{"id"}},
+		},
+	}
+	visits := &shared.SchemaInfo{
+		Table: "visits",
+		Columns: []shared.ColumnInfo{
+			{Name: "id", SQLType: "SERIAL", IsPrimaryKey: true},
+			{Name: "patient_id", SQLType: "INTEGER"},
+		},
+		ForeignKeys: []shared.ForeignKeyInfo{
+			{Name: "fk_visits_patient_id", Columns: []string{"patient_id"}, RefTable: "patients", RefColumns: []string{"id"}},
+		},
+	}
+
+	// Referenced tables are created first
+	want := "create table clinics,create table patients,create table visits"
+	if got := stepDescriptions(DiffSchemas(nil, []*shared.SchemaInfo{visits, patients, clinics})); strings.Join(got, ",") != want {
+		t.Errorf("DiffSchemas(nil, all) = %v, want %s", got, want)
+	}
+	if len(patients.ForeignKeys) != 1 {
+		t.Error("DiffSchemas modified the target schemas")
+	}
+
+	// Removed tables are dropped after their dependents, and a foreign key
+	// no longer declared is dropped
+	unlinked := *patients
+	unlinked.ForeignKeys = nil
+	want = "drop constraint fk_patients_clinic_id on patients,drop table visits (destructive),drop table clinics (destructive)"
+	got := stepDescriptions(DiffSchemas([]*shared.SchemaInfo{clinics, patients, visits}, []*shared.SchemaInfo{&unlinked}))
+	if strings.Join(got, ",") != want {
+		t.Errorf("DiffSchemas(all, patients) = %v, want %s", got, want)
+	}
+
+	if steps := DiffSchemas([]*shared.SchemaInfo{clinics, patients}, []*shared.SchemaInfo{clinics, patients}); len(steps) != 0 {
+		t.Errorf("DiffSchemas of unchanged schemas = %v, want none", stepDescriptions(steps))
+	}
+}
+
+// stepSQL returns the statements of steps
+func stepSQL(steps []MigrationStep) []string {
+	statements := make([]string, len(steps))
+	for i, step := range steps {
+		statements[i] = step.SQL
+	}
+	return statements
+}
+
+func TestDiffTableStepsComparesCheckDefinitions(t *testing.T) {
+	mb := NewMigrationBuilder()
+	target := &shared.SchemaInfo{
+		Table:   "patients",
+		Columns: []shared.ColumnInfo{{Name: "age", SQLType: "INTEGER", Check: "age >= 0"}},
+	}
+
+	// As introspected from pg_get_constraintdef
+	current := &shared.SchemaInfo{
+		Table:   "patients",
+		Columns: []shared.ColumnInfo{{Name: "age", SQLType: "integer"}},
+		Checks:  []shared.CheckInfo{{Name: "chk_patients_age", Expression: "((age >= 0))"}},
+	}
+	if steps := mb.diffTableSteps(current, target, false); len(steps) != 0 {
+		t.Errorf("unchanged check: steps = %v, want none", stepSQL(steps))
+	}
+
+	current.Checks[0].Expression = "((age > 0))"
+	want := []string{
+		"ALTER TABLE patients DROP CONSTRAINT IF EXISTS chk_patients_age",
+		"ALTER TABLE patients ADD CONSTRAINT chk_patients_age CHECK (age >= 0)",
+	}
+	steps := mb.diffTableSteps(current, target, false)
+	if got := stepSQL(steps); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("changed check: steps = %q, want %q", got, want)
+	}
+	// Existing rows may violate the new check, so the pair is skipped
+	// unless destructive steps are allowed
+	for _, step := range steps {
+		if !step.Destructive {
+			t.Errorf("changed check: step %q is not destructive", step.SQL)
+		}
+	}
+
+	current.Checks = nil
+	if steps := mb.diffTableSteps(current, target, false); len(steps) != 1 || !steps[0].Destructive {
+		t.Errorf("added check: steps = %+v, want one destructive step", steps)
+	}
+}
+
+func TestDiffTableStepsComparesIndexDefinitions(t *testing.T) {
+	mb := NewMigrationBuilder()
+	index := shared.IndexInfo{Name: "idx_patients_name", Columns: []string{"name"}, Where: "deleted_at IS NULL"}
+	target := &shared.SchemaInfo{
+		Table:   "patients",
+		Columns: []shared.ColumnInfo{{Name: "name", SQLType: "TEXT"}},
+		Indexes: []shared.IndexInfo{index},
+	}
+
+	tests := []struct {
+		name    string
+		current shared.IndexInfo
+		changed bool
+	}{
+		{"same", shared.IndexInfo{Method: "btree", Columns: []string{"name"}, Where: "(deleted_at IS NULL)"}, false},
+		{"unique", shared.IndexInfo{Unique: true, Method: "btree", Columns: []string{"name"}, Where: "deleted_at IS NULL"}, true},
+		{"method", shared.IndexInfo{Method: "hash", Columns: []string{"name"}, Where: "deleted_at IS NULL"}, true},
+		{"columns", shared.IndexInfo{Method: "btree", Columns: []string{"name", "id"}, Where: "deleted_at IS NULL"}, true},
+		{"ordering", shared.IndexInfo{Method: "btree", Columns: []string{"name DESC"}, Where: "deleted_at IS NULL"}, true},
+		{"predicate", shared.IndexInfo{Method: "btree", Columns: []string{"name"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.current.Name = index.Name
+			current := &shared.SchemaInfo{
+				Table:   "patients",
+				Columns: []shared.ColumnInfo{{Name: "name", SQLType: "text"}},
+				Indexes: []shared.IndexInfo{tt.current},
+			}
+
+			got := stepSQL(mb.diffTableSteps(current, target, false))
+			var want []string
+			if tt.changed {
+				want = []string{"DROP INDEX IF EXISTS idx_patients_name", mb.BuildCreateIndexSQL("patients", index)}
+			}
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("steps = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNormalizeExpression(t *testing.T) {
+	tests := []struct {
+		declared, deparsed string
+		equal              bool
+	}{
+		{"age >= 0", "((age >= 0))", true},
+		{"status != 'closed'", "((status)::text <> 'closed'::text)", true},
+		{"name <> ''", "(name <> ''::text)", true},
+		{"created_at < NOW()", "(created_at < now())", true},
+		{"price::NUMERIC(10, 2) > 0", "((price)::numeric(10,2) > (0)::numeric)", true},
+		{"status = 'Closed'", "((status)::text = 'closed'::text)", false},
+		{"age>0 AND age<150", "((age > 0) AND (age < 150))", true},
+		{"status IN ('active', 'closed')", "((status)::text = ANY ((ARRAY['active'::character varying, 'closed'::character varying])::text[]))", true},
+		{"status NOT IN ('closed')", "((status)::text <> ALL ((ARRAY['closed'::character varying])::text[]))", true},
+		{"note <> 'it''s'", "(note <> 'it''s'::text)", true},
+		{"age > 0", "((age >= 0))", false},
+		// Token boundaries are kept
+		{"a IS NOT NULL", "(aisnot NULL)", false},
+		{"status = 'a b'", "((status)::text = 'ab'::text)", false},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeExpression(tt.declared) == normalizeExpression(tt.deparsed); got != tt.equal {
+			t.Errorf("normalizeExpression(%q) == normalizeExpression(%q) is %v, want %v", tt.declared, tt.deparsed, got, tt.equal)
+		}
+	}
+}
+
+func TestDiffColumnStepsComments(t *testing.T) {
+	mb := NewMigrationBuilder()
+	tests := []struct {
+		current, target string
+		want            []string
+	}{
+		{"", "Contact address", []string{"COMMENT ON COLUMN patients.email IS 'Contact address'"}},
+		{"Old", "It's new", []string{"COMMENT ON COLUMN patients.email IS 'It''s new'"}},
+		{"Contact address", "", []string{"COMMENT ON COLUMN patients.email IS NULL"}},
+		{"Contact address", "Contact address", nil},
+		{"", "", nil},
+	}
+	for _, tt := range tests {
+		current := shared.ColumnInfo{Name: "email", SQLType: "text", Comment: tt.current}
+		target := shared.ColumnInfo{Name: "email", SQLType: "TEXT", Comment: tt.target}
+		got := stepSQL(mb.diffColumnSteps("patients", current, target, false))
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("comment %q -> %q: steps = %q, want %q", tt.current, tt.target, got, tt.want)
+		}
+	}
+}
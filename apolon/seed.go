@@ -0,0 +1,285 @@
+package apolon
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// Seed inserts the given rows of T or updates existing rows with the same
+// primary key, in one transaction, and returns the number of rows inserted
+// or changed. Seeding is idempotent, so reference data such as countries
+// or roles can be seeded on every start. Query filters do not apply.
+// If the key is a SERIAL column, its sequence is advanced past the seeded
+// keys, so later inserts do not collide with them.
+//
+// Rows configured with HasData are seeded by AutoMigrate or SeedData instead.
+func Seed[T any](ctx context.Context, db *DB, rows ...T) (int, error) {
+	model, err := db.models.Model(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	affected := 0
+	for _, row := range rows {
+		query, args, err := upsertSQL(model, reflect.Indirect(reflect.ValueOf(row)))
+		if err != nil {
+			return 0, err
+		}
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return 0, fmt.Errorf("seed failed: %w", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		affected += int(n)
+	}
+
+	if query, ok := setvalSQL(model); ok && len(rows) > 0 {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return 0, fmt.Errorf("seed failed: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return affected, nil
+}
+
+// upsertSQL generates an INSERT ... ON CONFLICT statement writing every
+// column of a row, matched by primary key. Existing rows are only
+// rewritten if a value differs.
+func upsertSQL(model *shared.Model, v reflect.Value) (string, []any, error) {
+	if len(model.Key) == 0 {
+		return "", nil, fmt.Errorf("seed failed: %s has no primary key", model.Type.Name())
+	}
+	// Single-column keys are generated on insert, so a zero key is unset
+	if len(model.Key) == 1 && isZeroValue(v.FieldByIndex(model.Key[0].Index).Interface()) {
+		return "", nil, fmt.Errorf("seed failed: %s row has no key", model.Type.Name())
+	}
+
+	var cols, placeholders, keys, sets, current, excluded []string
+	vals := make([]any, 0, len(model.Fields))
+	for _, field := range model.Fields {
+		col := field.Column.Name
+		vals = append(vals, v.FieldByIndex(field.Index).Interface())
+		cols = append(cols, col)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(vals)))
+
+		if model.IsKey(field) {
+			keys = append(keys, col)
+			continue
+		}
+		sets = append(sets, fmt.Sprintf("%s = EXCLUDED.%s", col, col))
+		current = append(current, "existing."+col)
+		excluded = append(excluded, "EXCLUDED."+col)
+	}
+
+	query := fmt.Sprintf(
+		"INSERT INTO %s AS existing (%s) VALUES (%s) ON CONFLICT (%s) ",
+		model.Table,
+		strings.Join(cols, ", "),
+		strings.Join(placeholders, ", "),
+		strings.Join(keys, ", "),
+	)
+	if len(sets) == 0 {
+		return query + "DO NOTHING", vals, nil
+	}
+	query += fmt.Sprintf(
+		"DO UPDATE SET %s WHERE (%s) IS DISTINCT FROM (%s)",
+		strings.Join(sets, ", "),
+		strings.Join(current, ", "),
+		strings.Join(excluded, ", "),
+	)
+	return query, vals, nil
+}
+
+// setvalSQL returns the statement advancing the sequence of a model's
+// SERIAL key past the largest key in its table. Seeded rows set their
+// keys explicitly, which does not advance the sequence.
+func setvalSQL(model *shared.Model) (string, bool) {
+	if len(model.Key) != 1 {
+		return "", false
+	}
+	col := model.Key[0].Column
+	switch strings.ToUpper(col.SQLType) {
+	case "SMALLSERIAL", "SERIAL", "BIGSERIAL":
+	default:
+		return "", false
+	}
+	return fmt.Sprintf("SELECT setval(pg_get_serial_sequence(%s, %s), max(%s)) FROM %s",
+		quoteLiteral(model.Table), quoteLiteral(col.Name), col.Name, model.Table), true
+}
+
+// SeedData upserts the HasData rows of the given entities' models that are
+// missing from the database or differ from it, like AutoMigrate does
+// after altering the tables, and returns the number of rows written. Use
+// it to seed tables managed by versioned migrations.
+func (db *DB) SeedData(ctx context.Context, entities ...any) (int, error) {
+	conn, unlock, err := db.lockMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	models, schemas, err := db.migrationSchemas(entities)
+	if err != nil {
+		return 0, err
+	}
+	current, err := db.introspectSchemas(ctx, conn, schemas)
+	if err != nil {
+		return 0, err
+	}
+	steps, err := db.seedSteps(ctx, conn, models, schemas, current)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.execStepsInTx(ctx, conn, steps); err != nil {
+		return 0, err
+	}
+
+	rows := 0
+	for _, step := range steps {
+		// Sequence steps have no arguments
+		if len(step.Args) > 0 {
+			rows++
+		}
+	}
+	return rows, nil
+}
+
+// seedSteps returns the steps upserting the HasData rows of the given
+// models that are missing from the database or differ from it, followed
+// by a step advancing the SERIAL key's sequence. Principal tables are
+// seeded before their dependents.
+func (db *DB) seedSteps(ctx context.Context, q querier, models []*shared.Model, schemas []*shared.SchemaInfo,
+	current map[string]*shared.SchemaInfo) ([]MigrationStep, error) {
+	byTable := make(map[string]*shared.Model, len(models))
+	copies := make([]*shared.SchemaInfo, len(schemas))
+	for i, schema := range schemas {
+		byTable[schema.Table] = models[i]
+		copied := *schema
+		copies[i] = &copied
+	}
+	ordered, _ := orderByReferences(copies)
+
+	var steps []MigrationStep
+	for _, schema := range ordered {
+		model := byTable[schema.Table]
+		seeded := false
+		for _, row := range model.Data {
+			v := reflect.Indirect(reflect.ValueOf(row))
+			query, args, err := upsertSQL(model, v)
+			if err != nil {
+				return nil, err
+			}
+
+			action, err := db.seedAction(ctx, q, model, v, current[schema.Table])
+			if err != nil {
+				return nil, err
+			}
+			if action == "" {
+				continue
+			}
+			seeded = true
+			steps = append(steps, MigrationStep{
+				SQL:         query,
+				Description: fmt.Sprintf("%s seed row %s (%s)", action, model.Table, formatArgs(keyValues(model, v), false)),
+				Args:        args,
+			})
+		}
+
+		if query, ok := setvalSQL(model); ok && seeded {
+			steps = append(steps, MigrationStep{
+				SQL:         query,
+				Description: fmt.Sprintf("advance the key sequence of %s", model.Table),
+			})
+		}
+	}
+	return steps, nil
+}
+
+// seedAction compares a seed row with the database and returns "insert"
+// if it is missing, "update" if it differs and "" if it is up to date.
+// Rows of tables that do not have all their columns yet are "upsert".
+func (db *DB) seedAction(ctx context.Context, q querier, model *shared.Model, v reflect.Value, existing *shared.SchemaInfo) (string, error) {
+	if existing == nil {
+		return "insert", nil
+	}
+
+	conds := make([]string, 0, len(model.Fields))
+	args := make([]any, 0, len(model.Fields)+len(model.Key))
+	for _, field := range model.Fields {
+		if findColumn(existing, field.Column.Name) == nil {
+			return "upsert", nil
+		}
+		args = append(args, v.FieldByIndex(field.Index).Interface())
+		conds = append(conds, fmt.Sprintf("%s IS NOT DISTINCT FROM $%d", field.Column.Name, len(args)))
+	}
+	where, keyArgs := keyPredicate(model.Key, keyValues(model, v), nil, len(args)+1)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(conds, " AND "), model.Table, where)
+
+	var same bool
+	err := q.QueryRowContext(ctx, query, append(args, keyArgs...)...).Scan(&same)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return "insert", nil
+	case err != nil:
+		return "", fmt.Errorf("failed to compare seed data of %s: %w", model.Table, err)
+	case same:
+		return "", nil
+	}
+	return "update", nil
+}
+
+// formatArgs renders statement arguments for display, numbered as
+// placeholders if numbered is set
+func formatArgs(args []any, numbered bool) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = formatArg(arg)
+		if numbered {
+			parts[i] = fmt.Sprintf("$%d = %s", i+1, parts[i])
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatArg renders a single argument as an SQL-like literal
+func formatArg(arg any) string {
+	if valuer, ok := arg.(driver.Valuer); ok {
+		if value, err := valuer.Value(); err == nil {
+			arg = value
+		}
+	}
+
+	v := reflect.ValueOf(arg)
+	for v.IsValid() && v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "NULL"
+		}
+		v = v.Elem()
+	}
+	switch {
+	case !v.IsValid():
+		return "NULL"
+	case v.Kind() == reflect.String:
+		return quoteLiteral(v.String())
+	}
+	return fmt.Sprint(v.Interface())
+}
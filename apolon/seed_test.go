@@ -0,0 +1,91 @@
+package apolon
+
+import (
+	"reflect"
+	"testing"
+)
+
+type seededRole struct {
+	ID   int    `apolon:"id,pk"`
+	Name string `apolon:"name"`
+}
+
+type seededCountry struct {
+	Code string `apolon:"code,pk"`
+}
+
+func TestUpsertSQL(t *testing.T) {
+	db := openTestDB(t)
+
+	tests := []struct {
+		name    string
+		row     any
+		want    string
+		args    int
+		wantErr bool
+	}{
+		{
+			name: "update changed rows",
+			row:  seededRole{ID: 1, Name: "admin"},
+			want: "INSERT INTO seededroles AS existing (id, name) VALUES ($1, $2) ON CONFLICT (id) " +
+				"DO UPDATE SET name = EXCLUDED.name WHERE (existing.name) IS DISTINCT FROM (EXCLUDED.name)",
+			args: 2,
+		},
+		{
+			name: "key only",
+			row:  seededCountry{Code: "HR"},
+			want: "INSERT INTO seededcountrys AS existing (code) VALUES ($1) ON CONFLICT (code) DO NOTHING",
+			args: 1,
+		},
+		{name: "missing key", row: seededRole{Name: "admin"}, wantErr: true},
+		{name: "composite key", row: trackedLine{OrderID: 1, LineNo: 2, Qty: 3}, args: 3,
+			want: "INSERT INTO trackedlines AS existing (order_id, line_no, qty) VALUES ($1, $2, $3) ON CONFLICT (order_id, line_no) " +
+				"DO UPDATE SET qty = EXCLUDED.qty WHERE (existing.qty) IS DISTINCT FROM (EXCLUDED.qty)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := db.models.Model(reflect.TypeOf(tt.row))
+			if err != nil {
+				t.Fatal(err)
+			}
+			query, args, err := upsertSQL(model, reflect.ValueOf(tt.row))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("upsertSQL(%+v) succeeded, want an error", tt.row)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != tt.want || len(args) != tt.args {
+				t.Errorf("upsertSQL(%+v) =\n%s with %d args, want\n%s with %d args", tt.row, query, len(args), tt.want, tt.args)
+			}
+		})
+	}
+}
+
+func TestSetvalSQL(t *testing.T) {
+	db := openTestDB(t)
+
+	model, err := db.models.Model(reflect.TypeOf(seededRole{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, ok := setvalSQL(model)
+	want := "SELECT setval(pg_get_serial_sequence('seededroles', 'id'), max(id)) FROM seededroles"
+	if !ok || query != want {
+		t.Errorf("setvalSQL() = %q, %v, want %q", query, ok, want)
+	}
+
+	for _, entity := range []any{seededCountry{}, trackedLine{}} {
+		model, err := db.models.Model(reflect.TypeOf(entity))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query, ok := setvalSQL(model); ok {
+			t.Errorf("setvalSQL(%T) = %q, want none for keys without a sequence", entity, query)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package apolon
+
+import (
+	"reflect"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// EntryNode describes an entity reached while walking a graph in TrackGraph
+type EntryNode struct {
+	Entity         any    // Pointer to the entity
+	Parent         any    // Entity that referenced this one, nil for the root
+	NavigationName string // Field on Parent through which the entity was reached
+	IsKeySet       bool   // Whether the primary key has a non-zero value
+}
+
+// foreignKeyLink records that a dependent's field holds a principal's key
+type foreignKeyLink struct {
+	principal *EntityEntry
+	field     string
+}
+
+// DefaultGraphState follows key-based conventions: entities without a key
+// are Added, entities with a key are Modified
+func DefaultGraphState(node EntryNode) shared.EntityState {
+	if node.IsKeySet {
+		return shared.Modified
+	}
+	return shared.Added
+}
+
+// TrackGraph walks root and every entity reachable through the navigations
+// of its model (*T, []T and []*T fields, including those of embedded
+// structs and unless ignored in OnModelCreating), tracking each node with
+// the state returned by callback. Returning Detached skips the node and
+// everything reachable only through it. Entities that are already tracked
+// are not revisited. A nil callback uses DefaultGraphState.
+//
+// Foreign keys follow the <Type>ID / <Navigation>ID naming convention and
+// are filled in from generated principal keys during SaveChanges.
+func (ct *ChangeTracker) TrackGraph(root any, callback func(node EntryNode) shared.EntityState) {
+	if callback == nil {
+		callback = DefaultGraphState
+	}
+	if checkEntity(root) != nil {
+		// Only pointers can be walked; Track records the error
+		ct.Track(root, callback(EntryNode{Entity: root}))
+		return
+	}
+	ct.trackGraphNode(EntryNode{Entity: root, IsKeySet: ct.isKeySet(root)}, callback, make(map[any]*EntityEntry))
+}
+
+// trackGraphNode tracks a node and its children, returning its entry or nil
+// if the node was skipped
+func (ct *ChangeTracker) trackGraphNode(node EntryNode, callback func(EntryNode) shared.EntityState,
+	visited map[any]*EntityEntry) *EntityEntry {
+	if entry, ok := visited[node.Entity]; ok {
+		return entry
+	}
+
+	ct.mu.RLock()
+	existing := ct.entries[node.Entity]
+	ct.mu.RUnlock()
+	if existing != nil {
+		visited[node.Entity] = existing
+		return existing
+	}
+
+	state := callback(node)
+	if state == shared.Detached {
+		visited[node.Entity] = nil
+		return nil
+	}
+
+	entry := ct.Track(node.Entity, state)
+	visited[node.Entity] = entry
+	if entry.model == nil {
+		return entry
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(node.Entity))
+	for _, nav := range entry.model.Navigations {
+		for _, child := range navigationValues(v.FieldByIndex(nav.Index)) {
+			childEntry := ct.trackGraphNode(EntryNode{
+				Entity:         child,
+				Parent:         node.Entity,
+				NavigationName: nav.Name,
+				IsKeySet:       ct.isKeySet(child),
+			}, callback, visited)
+
+			if childEntry != nil {
+				linkEntries(entry, childEntry, nav.Name)
+			}
+		}
+	}
+
+	return entry
+}
+
+// navigationValues returns pointers to the entities held by a navigation field
+func navigationValues(v reflect.Value) []any {
+	var values []any
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			values = append(values, v.Interface())
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			if elem.Kind() == reflect.Ptr {
+				if !elem.IsNil() {
+					values = append(values, elem.Interface())
+				}
+			} else {
+				values = append(values, elem.Addr().Interface())
+			}
+		}
+	}
+
+	return values
+}
+
+// linkEntries records the foreign key between two entries connected by a
+// navigation. A child with a <ParentType>ID field depends on the parent;
+// otherwise a parent with a <Navigation>ID or <ChildType>ID field depends
+// on the child.
+func linkEntries(parent, child *EntityEntry, navigation string) {
+	if field := foreignKeyField(child.model, parent.entityType.Name()+"ID"); field != "" {
+		child.principals = append(child.principals, foreignKeyLink{principal: parent, field: field})
+		return
+	}
+
+	if field := foreignKeyField(parent.model, navigation+"ID", child.entityType.Name()+"ID"); field != "" {
+		parent.principals = append(parent.principals, foreignKeyLink{principal: child, field: field})
+	}
+}
+
+// foreignKeyField returns the first candidate that names a mapped field of m
+func foreignKeyField(m *shared.Model, candidates ...string) string {
+	for _, name := range candidates {
+		if m.FieldByName(name) != nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// fixupForeignKeys copies principal keys into the dependent's foreign key fields
+func (e *EntityEntry) fixupForeignKeys() {
+	for _, link := range e.principals {
+		// Conventional foreign keys reference single-column keys only
+		keys := link.principal.KeyValues()
+		if len(keys) != 1 || isZeroValue(keys[0]) {
+			continue
+		}
+		e.Property(link.field).SetCurrentValue(keys[0])
+	}
+}
+
+// orderByDependencies sorts entries so that principals come before their
+// dependents; entries without links keep their relative order
+func orderByDependencies(entries []*EntityEntry) []*EntityEntry {
+	pending := make(map[*EntityEntry]bool, len(entries))
+	for _, entry := range entries {
+		pending[entry] = true
+	}
+
+	ordered := make([]*EntityEntry, 0, len(entries))
+	var visit func(entry *EntityEntry)
+	visit = func(entry *EntityEntry) {
+		if !pending[entry] {
+			return
+		}
+		delete(pending, entry)
+		for _, link := range entry.principals {
+			visit(link.principal)
+		}
+		ordered = append(ordered, entry)
+	}
+
+	for _, entry := range entries {
+		visit(entry)
+	}
+	return ordered
+}
+
+// isKeySet reports whether every primary key component of an entity is non-zero
+func (ct *ChangeTracker) isKeySet(entity any) bool {
+	model, _ := ct.db.models.ModelOf(entity)
+	_, ok := comparableKey(keyValues(model, reflect.Indirect(reflect.ValueOf(entity))))
+	return ok
+}
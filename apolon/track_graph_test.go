@@ -0,0 +1,175 @@
+package apolon
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/jkeresman01/apolon/apolon-shared"
+)
+
+// Graph types are exported, as foreign keys are named after the type,
+// e.g. GraphClinicID
+type GraphClinic struct {
+	ID       int    `apolon:"id,pk"`
+	Name     string `apolon:"name"`
+	Patients []*GraphPatient
+}
+
+// GraphContact is embedded, so its navigation belongs to GraphPatient
+type GraphContact struct {
+	Doctor *GraphDoctor
+}
+
+type GraphPatient struct {
+	GraphContact
+	ID            int `apolon:"id,pk"`
+	GraphClinicID int `apolon:"clinic_id"`
+	Nickname      *sql.NullString
+	Visits        []GraphVisit
+	Referrals     []*GraphPatient
+	Notes         *GraphNoteArchive
+}
+
+type GraphDoctor struct {
+	ID   int    `apolon:"id,pk"`
+	Name string `apolon:"name"`
+}
+
+type GraphVisit struct {
+	ID             int `apolon:"id,pk"`
+	GraphPatientID int `apolon:"patient_id"`
+}
+
+type GraphNoteArchive struct {
+	ID int `apolon:"id,pk"`
+}
+
+func TestNavigationsExcludeSQLValueTypes(t *testing.T) {
+	db := openTestDB(t)
+	model, err := db.models.Model(reflect.TypeOf(GraphPatient{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.FieldByName("Nickname") == nil {
+		t.Error("*sql.NullString field is not mapped to a column")
+	}
+	for _, nav := range model.Navigations {
+		if nav.Name == "Nickname" {
+			t.Error("*sql.NullString field is treated as a navigation")
+		}
+	}
+}
+
+func TestTrackGraphDefaultState(t *testing.T) {
+	db := openTestDB(t)
+
+	doctor := &GraphDoctor{ID: 7}
+	patient := &GraphPatient{GraphContact: GraphContact{Doctor: doctor}, Visits: []GraphVisit{{}, {ID: 3}}}
+	clinic := &GraphClinic{Patients: []*GraphPatient{patient}}
+	db.ChangeTracker.TrackGraph(clinic, nil)
+
+	states := map[any]shared.EntityState{
+		clinic:             shared.Added,
+		patient:            shared.Added,
+		doctor:             shared.Modified,
+		&patient.Visits[0]: shared.Added,
+		&patient.Visits[1]: shared.Modified,
+	}
+	for entity, want := range states {
+		entry := db.ChangeTracker.GetEntry(entity)
+		if entry == nil {
+			t.Errorf("%T %+v is not tracked", entity, entity)
+			continue
+		}
+		if entry.State != want {
+			t.Errorf("%T state = %v, want %v", entity, entry.State, want)
+		}
+	}
+	if n := len(db.ChangeTracker.Entries()); n != len(states) {
+		t.Errorf("tracked %d entries, want %d", n, len(states))
+	}
+}
+
+func TestTrackGraphCallback(t *testing.T) {
+	db := openTestDB(t)
+
+	referral := &GraphPatient{ID: 2, Visits: []GraphVisit{{ID: 5}}}
+	root := &GraphPatient{ID: 1, Referrals: []*GraphPatient{referral}}
+	root.Referrals = append(root.Referrals, root)
+
+	var nodes []EntryNode
+	db.ChangeTracker.TrackGraph(root, func(node EntryNode) shared.EntityState {
+		nodes = append(nodes, node)
+		if node.Entity == referral {
+			return shared.Detached
+		}
+		return shared.Unchanged
+	})
+
+	// The cycle back to root is not revisited and the skipped referral's
+	// visits are not reached
+	if len(nodes) != 2 {
+		t.Fatalf("callback called for %d nodes, want 2", len(nodes))
+	}
+	if nodes[0].Parent != nil || !nodes[0].IsKeySet {
+		t.Errorf("root node = %+v", nodes[0])
+	}
+	if nodes[1].Parent != root || nodes[1].NavigationName != "Referrals" {
+		t.Errorf("referral node = %+v, want parent root through Referrals", nodes[1])
+	}
+	if db.ChangeTracker.GetEntry(referral) != nil || db.ChangeTracker.GetEntry(&referral.Visits[0]) != nil {
+		t.Error("a skipped node or its children are tracked")
+	}
+}
+
+func TestTrackGraphRespectsIgnoredNavigations(t *testing.T) {
+	db, err := Open("postgres://localhost/apolon_test?sslmode=disable", OnModelCreating(func(mb *ModelBuilder) {
+		Entity[GraphPatient](mb).Ignore("Notes").Ignore("Doctor")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	patient := &GraphPatient{GraphContact: GraphContact{Doctor: &GraphDoctor{}}, Notes: &GraphNoteArchive{}}
+	db.ChangeTracker.TrackGraph(patient, nil)
+	if n := len(db.ChangeTracker.Entries()); n != 1 {
+		t.Errorf("tracked %d entries, want only the patient", n)
+	}
+}
+
+func TestForeignKeyFixupAndOrder(t *testing.T) {
+	db := openTestDB(t)
+
+	patient := &GraphPatient{Visits: []GraphVisit{{}}}
+	clinic := &GraphClinic{Patients: []*GraphPatient{patient}}
+	db.ChangeTracker.TrackGraph(clinic, nil)
+
+	clinicEntry := db.ChangeTracker.GetEntry(clinic)
+	patientEntry := db.ChangeTracker.GetEntry(patient)
+	visitEntry := db.ChangeTracker.GetEntry(&patient.Visits[0])
+
+	// Dependents come after their principals whatever the input order
+	ordered := orderByDependencies([]*EntityEntry{visitEntry, patientEntry, clinicEntry})
+	if ordered[0] != clinicEntry || ordered[1] != patientEntry || ordered[2] != visitEntry {
+		t.Errorf("orderByDependencies() = %v, want clinic, patient, visit", ordered)
+	}
+
+	// Keys generated on insert are copied into foreign keys
+	clinic.ID = 10
+	patientEntry.fixupForeignKeys()
+	patient.ID = 20
+	visitEntry.fixupForeignKeys()
+	if patient.GraphClinicID != 10 || patient.Visits[0].GraphPatientID != 20 {
+		t.Errorf("foreign keys = %d, %d, want 10, 20", patient.GraphClinicID, patient.Visits[0].GraphPatientID)
+	}
+
+	// Unset principal keys leave the foreign key alone
+	other := &GraphPatient{GraphClinicID: 3}
+	db.ChangeTracker.TrackGraph(&GraphClinic{Patients: []*GraphPatient{other}}, nil)
+	db.ChangeTracker.GetEntry(other).fixupForeignKeys()
+	if other.GraphClinicID != 3 {
+		t.Errorf("foreign key = %d, want 3 kept", other.GraphClinicID)
+	}
+}